@@ -0,0 +1,80 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Kinds of policy subjects.
+const (
+	UserKind           = "User"
+	GroupKind          = "Group"
+	ServiceAccountKind = "ServiceAccount"
+)
+
+// PolicySubject is a user, group or service account making requests.
+type PolicySubject struct {
+	// Kind is the kind of the subject.
+	// +kubebuilder:validation:Enum=User;Group;ServiceAccount
+	Kind string `json:"kind"`
+	// Name is the name of the subject. It may contain * wildcards.
+	Name string `json:"name"`
+	// Namespace is the namespace of a service account. It defaults to the
+	// namespace of the resource being replaced.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// PolicyRule allows reading keys of providers.
+type PolicyRule struct {
+	// Providers are the names of the providers. * allows any provider.
+	Providers []string `json:"providers"`
+	// Keys are the patterns of the keys which may be read, in which *
+	// matches any sequence of characters. Empty allows any key.
+	// +optional
+	Keys []string `json:"keys,omitempty"`
+	// Config are the provider options which resources may set, mapped to the
+	// patterns of their allowed values. Options change where keys are read
+	// from (e.g. the namespace or mount of vault), so resources setting any
+	// option which is not listed are denied.
+	// +optional
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// ReplacerPolicySpec is the desired state of a ReplacerPolicy.
+type ReplacerPolicySpec struct {
+	// Namespaces are the namespaces of the resources the policy applies to.
+	// They may contain * wildcards. Empty applies to all namespaces.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Subjects are the requesters the policy applies to. Empty applies to
+	// all requesters, including the controllers.
+	// +optional
+	Subjects []PolicySubject `json:"subjects,omitempty"`
+	// Rules are the providers and keys the policy allows.
+	Rules []PolicyRule `json:"rules"`
+}
+
+// ReplacerPolicy allows the resources in some namespaces, or created by some
+// users, to read keys of providers. Once any policy exists, keys are only read
+// if a policy allows it.
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type ReplacerPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ReplacerPolicySpec `json:"spec,omitempty"`
+}
+
+// ReplacerPolicyList is a list of ReplacerPolicies.
+// +kubebuilder:object:root=true
+type ReplacerPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ReplacerPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ReplacerPolicy{}, &ReplacerPolicyList{})
+}
@@ -0,0 +1,112 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionReady is true when the target has been rendered from the
+	// current template.
+	ConditionReady = "Ready"
+	// ConditionResolveFailed is true when the last render of the template
+	// failed. The target keeps the values of the last successful render.
+	ConditionResolveFailed = "ResolveFailed"
+)
+
+// TemplateTarget is the object generated from a template.
+type TemplateTarget struct {
+	// Kind is the kind of the target.
+	// +kubebuilder:validation:Enum=Secret;ConfigMap
+	Kind string `json:"kind"`
+	// Name is the name of the target. It defaults to the name of the template.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Type is the type of a secret.
+	// +optional
+	Type corev1.SecretType `json:"type,omitempty"`
+	// Labels and Annotations are added to the target.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ReplacerTemplateSpec is the desired state of a ReplacerTemplate.
+type ReplacerTemplateSpec struct {
+	// Target is the object generated from the template.
+	Target TemplateTarget `json:"target"`
+	// Data holds the templates of the values of the target. Values which
+	// are not valid utf-8 are stored in the binaryData of a configmap.
+	// +optional
+	Data map[string]string `json:"data,omitempty"`
+	// RefreshInterval is the interval at which the template is rendered
+	// again. It defaults to the interval of the controller.
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+}
+
+// KeyStatus is the status of a key of the target.
+type KeyStatus struct {
+	// Key is the data key.
+	Key string `json:"key"`
+	// Hash is the hash of the last rendered value.
+	Hash string `json:"hash"`
+	// Inputs is the hash of the template and the values read to render it.
+	// The rendered value is kept while the inputs stay the same.
+	// +optional
+	Inputs string `json:"inputs,omitempty"`
+	// Sources are the providers and keys of the tags in the template.
+	// +optional
+	Sources []string `json:"sources,omitempty"`
+	// LastChanged is the time at which the rendered value last changed.
+	LastChanged metav1.Time `json:"lastChanged"`
+}
+
+// ReplacerTemplateStatus is the observed state of a ReplacerTemplate.
+type ReplacerTemplateStatus struct {
+	// ObservedGeneration is the generation of the last rendered template.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the Ready and ResolveFailed conditions.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Keys are the status of each rendered key.
+	// +optional
+	Keys []KeyStatus `json:"keys,omitempty"`
+}
+
+// ReplacerTemplate generates a secret or configmap by replacing the tags in
+// its data. Replacer options are set with annotations as on other resources.
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Kind",type=string,JSONPath=`.spec.target.kind`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type ReplacerTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ReplacerTemplateSpec   `json:"spec,omitempty"`
+	Status ReplacerTemplateStatus `json:"status,omitempty"`
+}
+
+// TargetName returns the name of the target.
+func (t *ReplacerTemplate) TargetName() string {
+	if t.Spec.Target.Name != "" {
+		return t.Spec.Target.Name
+	}
+	return t.Name
+}
+
+// ReplacerTemplateList is a list of ReplacerTemplates.
+// +kubebuilder:object:root=true
+type ReplacerTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ReplacerTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ReplacerTemplate{}, &ReplacerTemplateList{})
+}
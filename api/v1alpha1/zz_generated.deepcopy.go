@@ -0,0 +1,315 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+// Written by hand in the format of controller-gen. It can be regenerated from
+// the +kubebuilder markers of the types with make generate.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyStatus) DeepCopyInto(out *KeyStatus) {
+	*out = *in
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastChanged.DeepCopyInto(&out.LastChanged)
+}
+
+// DeepCopy is an deepcopy function, copying the receiver, creating a new KeyStatus.
+func (in *KeyStatus) DeepCopy() *KeyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyRule) DeepCopyInto(out *PolicyRule) {
+	*out = *in
+	if in.Providers != nil {
+		in, out := &in.Providers, &out.Providers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an deepcopy function, copying the receiver, creating a new PolicyRule.
+func (in *PolicyRule) DeepCopy() *PolicyRule {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicySubject) DeepCopyInto(out *PolicySubject) {
+	*out = *in
+}
+
+// DeepCopy is an deepcopy function, copying the receiver, creating a new PolicySubject.
+func (in *PolicySubject) DeepCopy() *PolicySubject {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicySubject)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReplacerPolicy) DeepCopyInto(out *ReplacerPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an deepcopy function, copying the receiver, creating a new ReplacerPolicy.
+func (in *ReplacerPolicy) DeepCopy() *ReplacerPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ReplacerPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ReplacerPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReplacerPolicyList) DeepCopyInto(out *ReplacerPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ReplacerPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an deepcopy function, copying the receiver, creating a new ReplacerPolicyList.
+func (in *ReplacerPolicyList) DeepCopy() *ReplacerPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(ReplacerPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ReplacerPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReplacerPolicySpec) DeepCopyInto(out *ReplacerPolicySpec) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Subjects != nil {
+		in, out := &in.Subjects, &out.Subjects
+		*out = make([]PolicySubject, len(*in))
+		copy(*out, *in)
+	}
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an deepcopy function, copying the receiver, creating a new ReplacerPolicySpec.
+func (in *ReplacerPolicySpec) DeepCopy() *ReplacerPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ReplacerPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReplacerTemplate) DeepCopyInto(out *ReplacerTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an deepcopy function, copying the receiver, creating a new ReplacerTemplate.
+func (in *ReplacerTemplate) DeepCopy() *ReplacerTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(ReplacerTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ReplacerTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReplacerTemplateList) DeepCopyInto(out *ReplacerTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ReplacerTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an deepcopy function, copying the receiver, creating a new ReplacerTemplateList.
+func (in *ReplacerTemplateList) DeepCopy() *ReplacerTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(ReplacerTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ReplacerTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReplacerTemplateSpec) DeepCopyInto(out *ReplacerTemplateSpec) {
+	*out = *in
+	in.Target.DeepCopyInto(&out.Target)
+	if in.Data != nil {
+		in, out := &in.Data, &out.Data
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.RefreshInterval != nil {
+		in, out := &in.RefreshInterval, &out.RefreshInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an deepcopy function, copying the receiver, creating a new ReplacerTemplateSpec.
+func (in *ReplacerTemplateSpec) DeepCopy() *ReplacerTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ReplacerTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReplacerTemplateStatus) DeepCopyInto(out *ReplacerTemplateStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]KeyStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an deepcopy function, copying the receiver, creating a new ReplacerTemplateStatus.
+func (in *ReplacerTemplateStatus) DeepCopy() *ReplacerTemplateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ReplacerTemplateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TemplateTarget) DeepCopyInto(out *TemplateTarget) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an deepcopy function, copying the receiver, creating a new TemplateTarget.
+func (in *TemplateTarget) DeepCopy() *TemplateTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(TemplateTarget)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aar10n/replacer/internal/pkg/digest"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// restartAnnotation lists the workloads which are restarted when rotated
+	// values of an object are updated, e.g. deployment/api,statefulset/worker.
+	// With auto, all workloads referencing the object are restarted.
+	restartAnnotation = "replacer.agb.dev/restart"
+	// checksumAnnotation is set on the pod template of restarted workloads to
+	// the checksum of the updated values.
+	checksumAnnotation = "replacer.agb.dev/checksum"
+)
+
+// workload is a kind of workload which can be restarted.
+type workload struct {
+	newObject func() client.Object
+	newList   func() client.ObjectList
+	podSpecs  func(list client.ObjectList) map[string]*corev1.PodSpec
+}
+
+var workloads = map[string]workload{
+	"deployment": {
+		newObject: func() client.Object { return &appsv1.Deployment{} },
+		newList:   func() client.ObjectList { return &appsv1.DeploymentList{} },
+		podSpecs: func(list client.ObjectList) map[string]*corev1.PodSpec {
+			specs := make(map[string]*corev1.PodSpec)
+			for i := range list.(*appsv1.DeploymentList).Items {
+				item := &list.(*appsv1.DeploymentList).Items[i]
+				specs[item.Name] = &item.Spec.Template.Spec
+			}
+			return specs
+		},
+	},
+	"statefulset": {
+		newObject: func() client.Object { return &appsv1.StatefulSet{} },
+		newList:   func() client.ObjectList { return &appsv1.StatefulSetList{} },
+		podSpecs: func(list client.ObjectList) map[string]*corev1.PodSpec {
+			specs := make(map[string]*corev1.PodSpec)
+			for i := range list.(*appsv1.StatefulSetList).Items {
+				item := &list.(*appsv1.StatefulSetList).Items[i]
+				specs[item.Name] = &item.Spec.Template.Spec
+			}
+			return specs
+		},
+	},
+	"daemonset": {
+		newObject: func() client.Object { return &appsv1.DaemonSet{} },
+		newList:   func() client.ObjectList { return &appsv1.DaemonSetList{} },
+		podSpecs: func(list client.ObjectList) map[string]*corev1.PodSpec {
+			specs := make(map[string]*corev1.PodSpec)
+			for i := range list.(*appsv1.DaemonSetList).Items {
+				item := &list.(*appsv1.DaemonSetList).Items[i]
+				specs[item.Name] = &item.Spec.Template.Spec
+			}
+			return specs
+		},
+	},
+}
+
+// target is a workload to restart.
+type target struct {
+	kind string
+	name string
+}
+
+func (t target) String() string {
+	return t.kind + "/" + t.name
+}
+
+// restart restarts the workloads given by the restart annotation of obj by
+// setting the checksum of its values on their pod templates.
+func (r *RotationReconciler) restart(ctx context.Context, obj client.Object) error {
+	log := logf.FromContext(ctx)
+
+	targets, err := r.restartTargets(ctx, obj)
+	if err != nil {
+		return err
+	}
+
+	sum, err := checksum(obj)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{checksumAnnotation: sum},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		wl := workloads[t.kind].newObject()
+		wl.SetNamespace(obj.GetNamespace())
+		wl.SetName(t.name)
+		if err := r.Patch(ctx, wl, client.RawPatch(types.MergePatchType, patch)); err != nil {
+			return fmt.Errorf("failed to restart %s: %w", t, err)
+		}
+		log.Info("restarted workload", "workload", t.String())
+	}
+	return nil
+}
+
+// restartTargets returns the workloads given by the restart annotation of obj.
+func (r *RotationReconciler) restartTargets(ctx context.Context, obj client.Object) ([]target, error) {
+	value := strings.TrimSpace(obj.GetAnnotations()[restartAnnotation])
+	if value == "" {
+		return nil, nil
+	} else if value == "auto" {
+		return r.referencingTargets(ctx, obj)
+	}
+
+	var targets []target
+	for _, s := range strings.Split(value, ",") {
+		kind, name, ok := strings.Cut(strings.TrimSpace(s), "/")
+		kind = strings.ToLower(kind)
+		if _, known := workloads[kind]; !ok || !known || name == "" {
+			return nil, fmt.Errorf("invalid %s value: %s", restartAnnotation, value)
+		}
+		targets = append(targets, target{kind: kind, name: name})
+	}
+	return targets, nil
+}
+
+// referencingTargets returns the workloads in the namespace of obj whose pod
+// template references obj.
+func (r *RotationReconciler) referencingTargets(ctx context.Context, obj client.Object) ([]target, error) {
+	var targets []target
+	for _, kind := range []string{"deployment", "statefulset", "daemonset"} {
+		wl := workloads[kind]
+		list := wl.newList()
+		if err := r.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+			return nil, err
+		}
+
+		for name, spec := range wl.podSpecs(list) {
+			if references(spec, obj) {
+				targets = append(targets, target{kind: kind, name: name})
+			}
+		}
+	}
+	return targets, nil
+}
+
+// references returns true if a pod spec references a secret or configmap in
+// its volumes or environment.
+func references(spec *corev1.PodSpec, obj client.Object) bool {
+	_, isSecret := obj.(*corev1.Secret)
+	name := obj.GetName()
+
+	matches := func(secret, configMap string) bool {
+		if isSecret {
+			return secret == name
+		}
+		return configMap == name
+	}
+
+	for _, v := range spec.Volumes {
+		if v.Secret != nil && matches(v.Secret.SecretName, "") ||
+			v.ConfigMap != nil && matches("", v.ConfigMap.Name) {
+			return true
+		}
+		if v.Projected != nil {
+			for _, src := range v.Projected.Sources {
+				if src.Secret != nil && matches(src.Secret.Name, "") ||
+					src.ConfigMap != nil && matches("", src.ConfigMap.Name) {
+					return true
+				}
+			}
+		}
+	}
+
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, c := range containers {
+		for _, env := range c.EnvFrom {
+			if env.SecretRef != nil && matches(env.SecretRef.Name, "") ||
+				env.ConfigMapRef != nil && matches("", env.ConfigMapRef.Name) {
+				return true
+			}
+		}
+		for _, env := range c.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil && matches(ref.Name, "") {
+				return true
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil && matches("", ref.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checksum returns the checksum of the values of a secret or configmap.
+func checksum(obj client.Object) (string, error) {
+	var data interface{}
+	switch obj := obj.(type) {
+	case *corev1.Secret:
+		data = obj.Data
+	case *corev1.ConfigMap:
+		data = []interface{}{obj.Data, obj.BinaryData}
+	default:
+		return "", fmt.Errorf("cannot restart workloads of %T", obj)
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return digest.Sum(b), nil
+}
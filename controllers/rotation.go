@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/webhooks"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// refreshIntervalAnnotation sets the interval at which the templates of an
+// object are rendered again. Zero disables refreshing the object.
+const refreshIntervalAnnotation = "replacer.agb.dev/refresh_interval"
+
+// RotationReconciler renders the stored templates of secrets or configmaps
+// again at an interval, and updates them when any value changed. Workloads
+// given by the restart annotation are restarted after an update.
+type RotationReconciler struct {
+	client.Client
+	Webhook *webhooks.ReplacerWebhook
+	// Interval is the interval for objects without the refresh_interval
+	// annotation. Zero disables refreshing them.
+	Interval time.Duration
+
+	object client.Object
+	now    func() time.Time
+
+	lock     sync.Mutex
+	rendered map[types.NamespacedName]time.Time
+	restarts map[types.NamespacedName]bool // workloads to restart
+}
+
+// NewRotationReconciler returns a reconciler for the kind of object, which is
+// either a secret or a configmap.
+func NewRotationReconciler(c client.Client, w *webhooks.ReplacerWebhook, interval time.Duration, object client.Object) *RotationReconciler {
+	return &RotationReconciler{
+		Client:   c,
+		Webhook:  w,
+		Interval: interval,
+		object:   object,
+		now:      time.Now,
+		rendered: make(map[types.NamespacedName]time.Time),
+		restarts: make(map[types.NamespacedName]bool),
+	}
+}
+
+func (r *RotationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	obj := r.object.DeepCopyObject().(client.Object)
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		r.forget(req.NamespacedName)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	} else if !webhooks.HasTemplates(obj) {
+		r.forget(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	interval, err := r.interval(obj)
+	if err != nil {
+		log.Error(err, "invalid refresh interval")
+		return ctrl.Result{}, nil
+	} else if interval == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	// retry restarting the workloads after an update
+	if r.pendingRestart(req.NamespacedName) {
+		if err := r.restart(ctx, obj); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.setPendingRestart(req.NamespacedName, false)
+	}
+
+	// the object is reconciled on every change, but only rendered once per
+	// interval
+	if wait := r.lastRendered(req.NamespacedName).Add(interval).Sub(r.now()); wait > 0 {
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	rendered := obj.DeepCopyObject().(client.Object)
+	if _, err := r.Webhook.Render(ctx, rendered); err != nil {
+		if errors.Is(err, ierrors.ErrBackendError) || errors.Is(err, ierrors.ErrTimeout) ||
+			errors.Is(err, context.DeadlineExceeded) {
+			return ctrl.Result{}, err
+		}
+
+		// retrying will not help until the templates or upstream secrets change
+		log.Error(err, "failed to render templates")
+		r.setRendered(req.NamespacedName)
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+
+	if !equality.Semantic.DeepEqual(obj, rendered) {
+		if err := r.Update(ctx, rendered); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("updated rotated values")
+		r.setRendered(req.NamespacedName)
+
+		if err := r.restart(ctx, rendered); err != nil {
+			r.setPendingRestart(req.NamespacedName, true)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+
+	r.setRendered(req.NamespacedName)
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// SetupWithManager sets up the reconciler with the manager. It only runs on
+// the leader when leader election is enabled.
+func (r *RotationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	gvk, err := apiutil.GVKForObject(r.object, mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(strings.ToLower(gvk.Kind)+"-rotation").
+		For(r.object, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return webhooks.HasTemplates(obj)
+		}))).
+		Complete(r)
+}
+
+// interval returns the refresh interval of obj.
+func (r *RotationReconciler) interval(obj client.Object) (time.Duration, error) {
+	s, ok := obj.GetAnnotations()[refreshIntervalAnnotation]
+	if !ok {
+		return r.Interval, nil
+	}
+
+	interval, err := time.ParseDuration(s)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid %s value: %s", refreshIntervalAnnotation, s)
+	}
+	return interval, nil
+}
+
+func (r *RotationReconciler) lastRendered(key types.NamespacedName) time.Time {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rendered[key]
+}
+
+func (r *RotationReconciler) setRendered(key types.NamespacedName) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.rendered[key] = r.now()
+}
+
+func (r *RotationReconciler) pendingRestart(key types.NamespacedName) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.restarts[key]
+}
+
+func (r *RotationReconciler) setPendingRestart(key types.NamespacedName, pending bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if pending {
+		r.restarts[key] = true
+	} else {
+		delete(r.restarts, key)
+	}
+}
+
+func (r *RotationReconciler) forget(key types.NamespacedName) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.rendered, key)
+	delete(r.restarts, key)
+}
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aar10n/replacer/internal/pkg/providers"
+	"github.com/aar10n/replacer/internal/pkg/replacer"
+	"github.com/aar10n/replacer/webhooks"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestControllers(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Controllers Suite")
+}
+
+// testValues are the values of the controller-test provider.
+var testValues = map[string]string{}
+
+func init() {
+	providers.Register("controller-test", func() (providers.ValueProvider, error) {
+		return providers.NewTestProvider(testValues), nil
+	})
+}
+
+var _ = Describe("RotationReconciler", func() {
+	var (
+		ctx  = context.Background()
+		key  = types.NamespacedName{Namespace: "default", Name: "app"}
+		req  = ctrl.Request{NamespacedName: key}
+		hook *webhooks.ReplacerWebhook
+		c    client.Client
+		r    *RotationReconciler
+		now  time.Time
+	)
+
+	// create stores a secret rendered by the webhook.
+	create := func(annotations map[string]string) {
+		annotations["replacer.agb.dev/provider"] = "controller-test"
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace, Annotations: annotations},
+			Data:       map[string][]byte{"password": []byte("<replace:password>")},
+		}
+		_, err := hook.Render(ctx, secret)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Create(ctx, secret)).To(Succeed())
+	}
+
+	password := func() string {
+		secret := &corev1.Secret{}
+		Expect(c.Get(ctx, key, secret)).To(Succeed())
+		return string(secret.Data["password"])
+	}
+
+	BeforeEach(func() {
+		testValues["password"] = "v1"
+		hook = &webhooks.ReplacerWebhook{MaxConcurrency: replacer.DefaultMaxConcurrency}
+		c = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		r = NewRotationReconciler(c, hook, time.Hour, &corev1.Secret{})
+
+		now = time.Now()
+		r.now = func() time.Time { return now }
+	})
+
+	It("should update rotated values once per interval", func() {
+		create(map[string]string{})
+		Expect(password()).To(Equal("v1"))
+
+		testValues["password"] = "v2"
+		res, err := r.Reconcile(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.RequeueAfter).To(Equal(time.Hour))
+		Expect(password()).To(Equal("v2"))
+
+		testValues["password"] = "v3"
+		now = now.Add(time.Minute)
+		res, err = r.Reconcile(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.RequeueAfter).To(Equal(59 * time.Minute))
+		Expect(password()).To(Equal("v2"))
+
+		now = now.Add(time.Hour)
+		_, err = r.Reconcile(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(password()).To(Equal("v3"))
+	})
+
+	It("should use the interval of the object", func() {
+		create(map[string]string{refreshIntervalAnnotation: "5m"})
+		res, err := r.Reconcile(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.RequeueAfter).To(Equal(5 * time.Minute))
+
+		Expect(c.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}})).To(Succeed())
+		create(map[string]string{refreshIntervalAnnotation: "0"})
+		testValues["password"] = "v2"
+		res, err = r.Reconcile(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.RequeueAfter).To(BeZero())
+		Expect(password()).To(Equal("v1"))
+	})
+
+	It("should not update salted values while their inputs are unchanged", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace, Annotations: map[string]string{
+				"replacer.agb.dev/provider": "controller-test",
+			}},
+			Data: map[string][]byte{"password": []byte("<replace:password | bcrypt>")},
+		}
+		_, err := hook.Render(ctx, secret)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Create(ctx, secret)).To(Succeed())
+		hash := password()
+
+		_, err = r.Reconcile(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(password()).To(Equal(hash))
+
+		testValues["password"] = "v2"
+		now = now.Add(2 * time.Hour)
+		_, err = r.Reconcile(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(password()).ToNot(Equal(hash))
+	})
+
+	It("should keep values which cannot be rendered", func() {
+		create(map[string]string{})
+		delete(testValues, "password")
+
+		res, err := r.Reconcile(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.RequeueAfter).To(Equal(time.Hour))
+		Expect(password()).To(Equal("v1"))
+	})
+
+	Describe("restart", func() {
+		newDeployment := func(name string, spec corev1.PodSpec) *appsv1.Deployment {
+			return &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: key.Namespace},
+				Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: spec}},
+			}
+		}
+
+		checksums := func() map[string]string {
+			list := &appsv1.DeploymentList{}
+			Expect(c.List(ctx, list)).To(Succeed())
+			sums := make(map[string]string)
+			for _, d := range list.Items {
+				sums[d.Name] = d.Spec.Template.Annotations[checksumAnnotation]
+			}
+			return sums
+		}
+
+		BeforeEach(func() {
+			Expect(c.Create(ctx, newDeployment("api", corev1.PodSpec{}))).To(Succeed())
+			Expect(c.Create(ctx, newDeployment("web", corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name: "web",
+					EnvFrom: []corev1.EnvFromSource{{
+						SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: key.Name}},
+					}},
+				}},
+			}))).To(Succeed())
+		})
+
+		It("should restart the given workloads after an update", func() {
+			create(map[string]string{restartAnnotation: "deployment/api"})
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(checksums()).To(Equal(map[string]string{"api": "", "web": ""}))
+
+			testValues["password"] = "v2"
+			now = now.Add(2 * time.Hour)
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+
+			sums := checksums()
+			Expect(sums["api"]).To(HavePrefix("hmac-sha256:"))
+			Expect(sums["web"]).To(BeEmpty())
+		})
+
+		It("should restart the workloads referencing the object", func() {
+			create(map[string]string{restartAnnotation: "auto"})
+			testValues["password"] = "v2"
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+
+			sums := checksums()
+			Expect(sums["api"]).To(BeEmpty())
+			Expect(sums["web"]).To(HavePrefix("hmac-sha256:"))
+		})
+
+		It("should retry failed restarts", func() {
+			create(map[string]string{restartAnnotation: "deployment/missing"})
+			testValues["password"] = "v2"
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).To(HaveOccurred())
+			Expect(password()).To(Equal("v2"))
+
+			Expect(c.Create(ctx, newDeployment("missing", corev1.PodSpec{}))).To(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(checksums()["missing"]).To(HavePrefix("hmac-sha256:"))
+		})
+	})
+
+	It("should ignore deleted objects", func() {
+		res, err := r.Reconcile(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res).To(Equal(ctrl.Result{}))
+	})
+})
@@ -0,0 +1,348 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aar10n/replacer/api/v1alpha1"
+	"github.com/aar10n/replacer/internal/pkg/digest"
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/policy"
+	"github.com/aar10n/replacer/internal/pkg/providers"
+	"github.com/aar10n/replacer/internal/pkg/replacer"
+	"github.com/aar10n/replacer/webhooks"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// errTargetExists is returned when the target of a template exists but is not
+// owned by the template.
+var errTargetExists = errors.New("target exists and is not owned by the template")
+
+// targetLabels are set on the targets of templates. Targets are cached by
+// the manager, and skipped by the webhooks since they are already rendered.
+var targetLabels = map[string]string{
+	webhooks.ManagedLabel: "true",
+	"part-of":             "replacer",
+}
+
+// TemplateReconciler renders ReplacerTemplates into the secrets or configmaps
+// they own.
+type TemplateReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Pool holds the providers shared with the webhook (optional).
+	Pool *providers.Pool
+	// RateLimiter limits the calls to providers (optional).
+	RateLimiter *providers.RateLimiter
+	// MaxConcurrency is the maximum number of values fetched concurrently
+	// for a single template.
+	MaxConcurrency int
+	// Interval is the refresh interval of templates which do not set one.
+	// Zero disables refreshing them.
+	Interval time.Duration
+	// Timeout limits the time for rendering a template.
+	Timeout time.Duration
+	// EnforcePolicies limits the keys read for each template to those allowed
+	// by the ReplacerPolicies without subjects.
+	EnforcePolicies bool
+
+	now func() time.Time
+}
+
+func (r *TemplateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	tmpl := &v1alpha1.ReplacerTemplate{}
+	if err := r.Get(ctx, req.NamespacedName, tmpl); err != nil {
+		// the target is deleted through its owner reference
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	interval := r.Interval
+	if tmpl.Spec.RefreshInterval != nil {
+		interval = tmpl.Spec.RefreshInterval.Duration
+	}
+	result := ctrl.Result{RequeueAfter: interval}
+
+	status := tmpl.Status.DeepCopy()
+	status.ObservedGeneration = tmpl.Generation
+
+	values, sources, inputs, err := r.render(ctx, tmpl)
+	if err == nil {
+		err = r.apply(ctx, tmpl, values, inputs)
+	}
+	if err != nil {
+		log.Error(err, "failed to render template")
+		reason := "ResolveFailed"
+		if errors.Is(err, errTargetExists) {
+			reason = "TargetExists"
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    v1alpha1.ConditionReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  reason,
+			Message: err.Error(),
+		})
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    v1alpha1.ConditionResolveFailed,
+			Status:  metav1.ConditionTrue,
+			Reason:  reason,
+			Message: err.Error(),
+		})
+		if uerr := r.updateStatus(ctx, tmpl, status); uerr != nil {
+			return ctrl.Result{}, uerr
+		}
+
+		// retry errors of backends, other errors need changes to the template
+		// or upstream secrets
+		if errors.Is(err, ierrors.ErrBackendError) || errors.Is(err, ierrors.ErrTimeout) ||
+			errors.Is(err, context.DeadlineExceeded) {
+			return ctrl.Result{}, err
+		}
+		return result, nil
+	}
+
+	status.Keys = keyStatus(tmpl.Status.Keys, values, sources, inputs, r.clock())
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    v1alpha1.ConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Rendered",
+		Message: fmt.Sprintf("rendered %s %s", tmpl.Spec.Target.Kind, tmpl.TargetName()),
+	})
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:   v1alpha1.ConditionResolveFailed,
+		Status: metav1.ConditionFalse,
+		Reason: "Resolved",
+	})
+	if err := r.updateStatus(ctx, tmpl, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// SetupWithManager sets up the reconciler with the manager.
+func (r *TemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.ReplacerTemplate{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+		))).
+		Owns(&corev1.Secret{}).
+		Owns(&corev1.ConfigMap{}).
+		Complete(r)
+}
+
+// render replaces the tags in the data of a template. It returns the values,
+// the sources and the hash of the inputs of each key.
+func (r *TemplateReconciler) render(ctx context.Context, tmpl *v1alpha1.ReplacerTemplate) (map[string]string, map[string][]string, map[string]string, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	var authorize replacer.AuthorizeFunc
+	if r.EnforcePolicies {
+		policies, err := policy.List(ctx, r.Client)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		authorize = policies.Authorizer(webhooks.TemplateSubject(tmpl))
+	}
+
+	rep, err := replacer.New(tmpl.Annotations,
+		replacer.WithNamespace(tmpl.Namespace),
+		replacer.WithPool(r.Pool),
+		replacer.WithRateLimiter(r.RateLimiter),
+		replacer.WithMaxConcurrency(r.MaxConcurrency),
+		replacer.WithAuthorizer(authorize),
+	)
+	if err != nil {
+		return nil, nil, nil, ierrors.Wrap(ierrors.InvalidArgument, err)
+	}
+	defer rep.Close()
+
+	values, err := rep.ReplaceMap(ctx, tmpl.Spec.Data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sources := make(map[string][]string)
+	inputs := make(map[string]string)
+	for k, v := range tmpl.Spec.Data {
+		srcs, _ := rep.Sources(v)
+		for _, src := range srcs {
+			sources[k] = append(sources[k], src.String())
+		}
+		if in, err := rep.Inputs(ctx, v); err == nil {
+			inputs[k] = digest.Inputs(v, in)
+		}
+	}
+	return values, sources, inputs, nil
+}
+
+// apply creates or updates the target of a template with the rendered values.
+// Values of the target are kept while the inputs of their keys are the same,
+// so that filters adding random salts (e.g. bcrypt) do not change them on
+// every render. The kept values are set in values.
+func (r *TemplateReconciler) apply(ctx context.Context, tmpl *v1alpha1.ReplacerTemplate, values map[string]string, inputs map[string]string) error {
+	var target client.Object
+	switch tmpl.Spec.Target.Kind {
+	case "Secret":
+		target = &corev1.Secret{}
+	case "ConfigMap":
+		target = &corev1.ConfigMap{}
+	default:
+		return ierrors.New(ierrors.InvalidArgument, "invalid target kind: "+tmpl.Spec.Target.Kind)
+	}
+	target.SetNamespace(tmpl.Namespace)
+	target.SetName(tmpl.TargetName())
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, target, func() error {
+		if target.GetResourceVersion() != "" && !metav1.IsControlledBy(target, tmpl) {
+			return errTargetExists
+		}
+
+		keepValues(tmpl.Status.Keys, targetValues(target), values, inputs)
+		target.SetLabels(mergeMaps(mergeMaps(target.GetLabels(), tmpl.Spec.Target.Labels), targetLabels))
+		target.SetAnnotations(mergeMaps(target.GetAnnotations(), tmpl.Spec.Target.Annotations))
+		switch target := target.(type) {
+		case *corev1.Secret:
+			if target.ResourceVersion == "" {
+				target.Type = tmpl.Spec.Target.Type
+			}
+			target.Data = make(map[string][]byte, len(values))
+			for k, v := range values {
+				target.Data[k] = []byte(v)
+			}
+		case *corev1.ConfigMap:
+			target.Data, target.BinaryData = nil, nil
+			for k, v := range values {
+				if utf8.ValidString(v) {
+					if target.Data == nil {
+						target.Data = make(map[string]string)
+					}
+					target.Data[k] = v
+				} else {
+					if target.BinaryData == nil {
+						target.BinaryData = make(map[string][]byte)
+					}
+					target.BinaryData[k] = []byte(v)
+				}
+			}
+		}
+		return controllerutil.SetControllerReference(tmpl, target, r.Scheme)
+	})
+	if apierrors.IsAlreadyExists(err) {
+		// objects without the managed label are not cached, so an existing
+		// object is only found when creating the target
+		return errTargetExists
+	}
+	return err
+}
+
+// updateStatus updates the status of a template if it changed.
+func (r *TemplateReconciler) updateStatus(ctx context.Context, tmpl *v1alpha1.ReplacerTemplate, status *v1alpha1.ReplacerTemplateStatus) error {
+	if equality.Semantic.DeepEqual(&tmpl.Status, status) {
+		return nil
+	}
+	tmpl.Status = *status
+	return r.Status().Update(ctx, tmpl)
+}
+
+func (r *TemplateReconciler) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+// keepValues sets the values of keys whose inputs are the same as in their
+// status to the current values of the target, unless those were changed.
+func keepValues(status []v1alpha1.KeyStatus, current map[string]string, values map[string]string, inputs map[string]string) {
+	for _, ks := range status {
+		cur, ok := current[ks.Key]
+		if !ok || ks.Inputs == "" || ks.Inputs != inputs[ks.Key] || !digest.Match(ks.Hash, cur) {
+			continue
+		}
+		if _, ok := values[ks.Key]; ok {
+			values[ks.Key] = cur
+		}
+	}
+}
+
+// targetValues returns the values of the target of a template.
+func targetValues(target client.Object) map[string]string {
+	values := make(map[string]string)
+	switch target := target.(type) {
+	case *corev1.Secret:
+		for k, v := range target.Data {
+			values[k] = string(v)
+		}
+	case *corev1.ConfigMap:
+		for k, v := range target.Data {
+			values[k] = v
+		}
+		for k, v := range target.BinaryData {
+			values[k] = string(v)
+		}
+	}
+	return values
+}
+
+// keyStatus returns the status of the rendered keys. The time at which a key
+// last changed is kept while its hash stays the same.
+func keyStatus(old []v1alpha1.KeyStatus, values map[string]string, sources map[string][]string, inputs map[string]string, now time.Time) []v1alpha1.KeyStatus {
+	prev := make(map[string]v1alpha1.KeyStatus, len(old))
+	for _, ks := range old {
+		prev[ks.Key] = ks
+	}
+
+	keys := make([]v1alpha1.KeyStatus, 0, len(values))
+	for k, v := range values {
+		ks := v1alpha1.KeyStatus{
+			Key:         k,
+			Hash:        digest.SumString(v),
+			Inputs:      inputs[k],
+			Sources:     sources[k],
+			LastChanged: metav1.NewTime(now),
+		}
+		if p, ok := prev[k]; ok && p.Hash == ks.Hash {
+			ks.LastChanged = p.LastChanged
+		}
+		keys = append(keys, ks)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Key < keys[j].Key
+	})
+	return keys
+}
+
+// mergeMaps returns m with the values of other added.
+func mergeMaps(m map[string]string, other map[string]string) map[string]string {
+	if len(other) == 0 {
+		return m
+	} else if m == nil {
+		m = make(map[string]string, len(other))
+	}
+	for k, v := range other {
+		m[k] = v
+	}
+	return m
+}
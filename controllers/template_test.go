@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/aar10n/replacer/api/v1alpha1"
+	"github.com/aar10n/replacer/internal/pkg/digest"
+	"github.com/aar10n/replacer/internal/pkg/replacer"
+	"github.com/aar10n/replacer/webhooks"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("TemplateReconciler", func() {
+	var (
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "app"}
+		req = ctrl.Request{NamespacedName: key}
+		c   client.Client
+		r   *TemplateReconciler
+		now time.Time
+	)
+
+	newTemplate := func(kind string, data map[string]string) *v1alpha1.ReplacerTemplate {
+		return &v1alpha1.ReplacerTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace, Annotations: map[string]string{
+				"replacer.agb.dev/provider": "controller-test",
+			}},
+			Spec: v1alpha1.ReplacerTemplateSpec{
+				Target: v1alpha1.TemplateTarget{Kind: kind, Labels: map[string]string{"app": "api"}},
+				Data:   data,
+			},
+		}
+	}
+
+	getTemplate := func() *v1alpha1.ReplacerTemplate {
+		tmpl := &v1alpha1.ReplacerTemplate{}
+		Expect(c.Get(ctx, key, tmpl)).To(Succeed())
+		return tmpl
+	}
+
+	reconcile := func() {
+		res, err := r.Reconcile(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.RequeueAfter).To(Equal(time.Hour))
+	}
+
+	BeforeEach(func() {
+		testValues["password"] = "v1"
+		testValues["keystore"] = "/wD+YQ=="
+
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+		c = fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		now = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		r = &TemplateReconciler{
+			Client:         c,
+			Scheme:         scheme,
+			MaxConcurrency: replacer.DefaultMaxConcurrency,
+			Interval:       time.Hour,
+			now:            func() time.Time { return now },
+		}
+	})
+
+	It("should render an owned secret", func() {
+		Expect(c.Create(ctx, newTemplate("Secret", map[string]string{
+			"password": "<replace:password>",
+			"plain":    "plain",
+		}))).To(Succeed())
+		reconcile()
+
+		secret := &corev1.Secret{}
+		Expect(c.Get(ctx, key, secret)).To(Succeed())
+		Expect(secret.Data).To(Equal(map[string][]byte{"password": []byte("v1"), "plain": []byte("plain")}))
+		Expect(secret.Labels).To(Equal(map[string]string{
+			"app":                      "api",
+			"part-of":                  "replacer",
+			"replacer.agb.dev/managed": "true",
+		}))
+		Expect(metav1.IsControlledBy(secret, getTemplate())).To(BeTrue())
+
+		tmpl := getTemplate()
+		Expect(meta.IsStatusConditionTrue(tmpl.Status.Conditions, v1alpha1.ConditionReady)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(tmpl.Status.Conditions, v1alpha1.ConditionResolveFailed)).To(BeTrue())
+		Expect(tmpl.Status.Keys).To(HaveLen(2))
+		ks := tmpl.Status.Keys[0]
+		Expect(ks.Key).To(Equal("password"))
+		Expect(ks.Hash).To(Equal(digest.SumString("v1")))
+		Expect(ks.Sources).To(Equal([]string{"controller-test:password"}))
+		Expect(ks.LastChanged.Time).To(BeTemporally("==", now))
+	})
+
+	It("should store binary values of a configmap in binaryData", func() {
+		Expect(c.Create(ctx, newTemplate("ConfigMap", map[string]string{
+			"password":     "<replace:password>",
+			"keystore.p12": "<replace:keystore | b64dec>",
+		}))).To(Succeed())
+		reconcile()
+
+		cm := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, key, cm)).To(Succeed())
+		Expect(cm.Data).To(Equal(map[string]string{"password": "v1"}))
+		Expect(cm.BinaryData).To(Equal(map[string][]byte{"keystore.p12": {0xff, 0x00, 0xfe, 'a'}}))
+	})
+
+	It("should track when values change", func() {
+		Expect(c.Create(ctx, newTemplate("Secret", map[string]string{"password": "<replace:password>"}))).To(Succeed())
+		reconcile()
+		first := now
+
+		now = now.Add(time.Hour)
+		reconcile()
+		Expect(getTemplate().Status.Keys[0].LastChanged.Time).To(BeTemporally("==", first))
+
+		testValues["password"] = "v2"
+		now = now.Add(time.Hour)
+		reconcile()
+		Expect(getTemplate().Status.Keys[0].LastChanged.Time).To(BeTemporally("==", now))
+
+		secret := &corev1.Secret{}
+		Expect(c.Get(ctx, key, secret)).To(Succeed())
+		Expect(secret.Data).To(Equal(map[string][]byte{"password": []byte("v2")}))
+	})
+
+	It("should keep salted values while their inputs are unchanged", func() {
+		Expect(c.Create(ctx, newTemplate("Secret", map[string]string{"password": "<replace:password | bcrypt>"}))).To(Succeed())
+		reconcile()
+
+		hash := func() []byte {
+			secret := &corev1.Secret{}
+			Expect(c.Get(ctx, key, secret)).To(Succeed())
+			return secret.Data["password"]
+		}
+		first := hash()
+
+		now = now.Add(time.Hour)
+		reconcile()
+		Expect(hash()).To(Equal(first))
+		Expect(getTemplate().Status.Keys[0].Hash).To(Equal(digest.SumString(string(first))))
+
+		testValues["password"] = "v2"
+		reconcile()
+		Expect(hash()).ToNot(Equal(first))
+		Expect(getTemplate().Status.Keys[0].LastChanged.Time).To(BeTemporally("==", now))
+	})
+
+	It("should keep the target when rendering fails", func() {
+		Expect(c.Create(ctx, newTemplate("Secret", map[string]string{"password": "<replace:password>"}))).To(Succeed())
+		reconcile()
+
+		delete(testValues, "password")
+		reconcile()
+
+		tmpl := getTemplate()
+		Expect(meta.IsStatusConditionTrue(tmpl.Status.Conditions, v1alpha1.ConditionResolveFailed)).To(BeTrue())
+		Expect(meta.FindStatusCondition(tmpl.Status.Conditions, v1alpha1.ConditionReady).Message).To(ContainSubstring("not found"))
+
+		secret := &corev1.Secret{}
+		Expect(c.Get(ctx, key, secret)).To(Succeed())
+		Expect(secret.Data).To(Equal(map[string][]byte{"password": []byte("v1")}))
+	})
+
+	It("should not take over existing objects", func() {
+		Expect(c.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+			Data:       map[string][]byte{"password": []byte("other")},
+		})).To(Succeed())
+		Expect(c.Create(ctx, newTemplate("Secret", map[string]string{"password": "<replace:password>"}))).To(Succeed())
+		reconcile()
+
+		ready := meta.FindStatusCondition(getTemplate().Status.Conditions, v1alpha1.ConditionReady)
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Reason).To(Equal("TargetExists"))
+
+		secret := &corev1.Secret{}
+		Expect(c.Get(ctx, key, secret)).To(Succeed())
+		Expect(secret.Data).To(Equal(map[string][]byte{"password": []byte("other")}))
+	})
+
+	It("should only read keys allowed by policies", func() {
+		testValues["user"] = "admin"
+		Expect(c.Create(ctx, &v1alpha1.ReplacerPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: v1alpha1.ReplacerPolicySpec{
+				Namespaces: []string{key.Namespace},
+				Rules:      []v1alpha1.PolicyRule{{Providers: []string{"controller-test"}, Keys: []string{"user"}}},
+			},
+		})).To(Succeed())
+		Expect(c.Create(ctx, newTemplate("Secret", map[string]string{
+			"user":     "<replace:user>",
+			"password": "<replace:password>",
+		}))).To(Succeed())
+		r.EnforcePolicies = true
+		reconcile()
+
+		ready := meta.FindStatusCondition(getTemplate().Status.Conditions, v1alpha1.ConditionReady)
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Message).To(ContainSubstring("key password of provider controller-test is not allowed"))
+		Expect(c.Get(ctx, key, &corev1.Secret{})).ToNot(Succeed())
+	})
+
+	It("should apply the policies of the requester of a template", func() {
+		Expect(c.Create(ctx, &v1alpha1.ReplacerPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "alice"},
+			Spec: v1alpha1.ReplacerPolicySpec{
+				Subjects: []v1alpha1.PolicySubject{{Kind: v1alpha1.UserKind, Name: "alice"}},
+				Rules:    []v1alpha1.PolicyRule{{Providers: []string{"controller-test"}}},
+			},
+		})).To(Succeed())
+		tmpl := newTemplate("Secret", map[string]string{"password": "<replace:password>"})
+		Expect(c.Create(ctx, tmpl)).To(Succeed())
+		r.EnforcePolicies = true
+
+		reconcile()
+		ready := meta.FindStatusCondition(getTemplate().Status.Conditions, v1alpha1.ConditionReady)
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+
+		tmpl = getTemplate()
+		Expect(webhooks.SetRequester(tmpl, "alice", nil)).To(Succeed())
+		Expect(c.Update(ctx, tmpl)).To(Succeed())
+		reconcile()
+		Expect(meta.IsStatusConditionTrue(getTemplate().Status.Conditions, v1alpha1.ConditionReady)).To(BeTrue())
+	})
+})
@@ -0,0 +1,121 @@
+// Package digest computes the keyed hashes of values which are stored in
+// objects, e.g. to detect whether a rendered value was changed. Hashes are
+// keyed with a secret key of the manager, so that values cannot be guessed
+// offline from them.
+package digest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/aar10n/replacer/internal/pkg/replacer"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	hmacPrefix = "hmac-sha256:"
+
+	// keyField is the field of the key in its secret.
+	keyField = "key"
+	keySize  = 32
+)
+
+var (
+	lock sync.RWMutex
+	key  []byte
+)
+
+// SetKey sets the key of the hashes. It must be the same on every replica.
+func SetKey(k []byte) {
+	lock.Lock()
+	defer lock.Unlock()
+	key = append([]byte(nil), k...)
+}
+
+// Sum returns the keyed hash of a value.
+func Sum(b []byte) string {
+	lock.RLock()
+	mac := hmac.New(sha256.New, key)
+	lock.RUnlock()
+
+	mac.Write(b)
+	return hmacPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SumString returns the keyed hash of a string.
+func SumString(s string) string {
+	return Sum([]byte(s))
+}
+
+// Inputs returns the keyed hash of a template and the inputs read to render
+// it. Renders of the template have the same hash while its inputs are the
+// same, even if the rendered values differ because of random salts.
+func Inputs(template string, inputs []replacer.Input) string {
+	b, _ := json.Marshal(struct {
+		Template string           `json:"template"`
+		Inputs   []replacer.Input `json:"inputs"`
+	}{template, inputs})
+	return Sum(b)
+}
+
+// Match returns true if hash is the hash of a value.
+func Match(hash string, s string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(SumString(s))) == 1
+}
+
+// LoadKey returns the key stored in a secret, creating the secret with a
+// random key if it does not exist. The secret is labeled as part of the
+// replacer, so that it is not handled by the webhooks.
+func LoadKey(ctx context.Context, c client.Client, reader client.Reader, name types.NamespacedName) ([]byte, error) {
+	if name.Namespace == "" {
+		return nil, fmt.Errorf("missing namespace of the hash key secret %s", name.Name)
+	}
+
+	secret := &corev1.Secret{}
+	err := reader.Get(ctx, name, secret)
+	if apierrors.IsNotFound(err) {
+		k := make([]byte, keySize)
+		if _, err := rand.Read(k); err != nil {
+			return nil, err
+		}
+
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name.Name,
+				Namespace: name.Namespace,
+				Labels:    map[string]string{"part-of": "replacer"},
+			},
+			Data: map[string][]byte{keyField: k},
+		}
+		err = c.Create(ctx, secret)
+		if err == nil {
+			return k, nil
+		} else if !apierrors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("failed to create the hash key secret %s: %w", name, err)
+		}
+
+		// another replica created the key first
+		err = reader.Get(ctx, name, secret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the hash key secret %s: %w", name, err)
+	}
+
+	k := secret.Data[keyField]
+	if len(k) < keySize {
+		return nil, fmt.Errorf("the hash key secret %s must have a %s of at least %d bytes", name, keyField, keySize)
+	}
+	return k, nil
+}
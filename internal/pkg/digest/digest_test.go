@@ -0,0 +1,79 @@
+package digest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/aar10n/replacer/internal/pkg/replacer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDigest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Digest Suite")
+}
+
+var _ = Describe("Digest", func() {
+	AfterEach(func() {
+		SetKey(nil)
+	})
+
+	It("should hash values with the key", func() {
+		SetKey([]byte("a"))
+		sum := SumString("hunter2")
+		Expect(sum).To(HavePrefix("hmac-sha256:"))
+		Expect(Match(sum, "hunter2")).To(BeTrue())
+		Expect(Match(sum, "hunter3")).To(BeFalse())
+
+		SetKey([]byte("b"))
+		Expect(SumString("hunter2")).ToNot(Equal(sum))
+		Expect(Match(sum, "hunter2")).To(BeFalse())
+	})
+
+	It("should not match unkeyed hashes", func() {
+		sum := sha256.Sum256([]byte("hunter2"))
+		Expect(Match("sha256:"+hex.EncodeToString(sum[:]), "hunter2")).To(BeFalse())
+	})
+
+	It("should hash the template and inputs", func() {
+		inputs := []replacer.Input{{Source: replacer.Source{Provider: "gcp", Key: "db"}, Value: "v1"}}
+		sum := Inputs("<replace:db | bcrypt>", inputs)
+		Expect(Inputs("<replace:db | bcrypt>", inputs)).To(Equal(sum))
+		Expect(Inputs("<replace:db | bcrypt 12>", inputs)).ToNot(Equal(sum))
+
+		inputs[0].Value = "v2"
+		Expect(Inputs("<replace:db | bcrypt>", inputs)).ToNot(Equal(sum))
+	})
+
+	Describe("LoadKey", func() {
+		name := types.NamespacedName{Namespace: "replacer-system", Name: "replacer-hash-key"}
+
+		It("should create a random key once", func() {
+			c := fake.NewClientBuilder().Build()
+			k1, err := LoadKey(context.Background(), c, c, name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(k1).To(HaveLen(32))
+
+			k2, err := LoadKey(context.Background(), c, c, name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(k2).To(Equal(k1))
+		})
+
+		It("should reject short keys", func() {
+			c := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: name.Name, Namespace: name.Namespace},
+				Data:       map[string][]byte{"key": []byte("short")},
+			}).Build()
+			_, err := LoadKey(context.Background(), c, c, name)
+			Expect(err).To(MatchError(ContainSubstring("at least 32 bytes")))
+		})
+	})
+})
@@ -1,11 +1,134 @@
 package errors
 
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Code classifies an error.
+type Code string
+
 const (
-	ErrNotFound         = "not found"
-	ErrPermissionDenied = "permission denied"
-	ErrInvalidProvider  = "invalid provider"
-	ErrInvalidArgument  = "invalid argument"
-	ErrBackendError     = "backend error"
-	ErrProviderError    = "provider error"
-	ErrInternalError    = "internal error"
+	NotFound         Code = "not found"
+	PermissionDenied Code = "permission denied"
+	InvalidProvider  Code = "invalid provider"
+	InvalidArgument  Code = "invalid argument"
+	BackendError     Code = "backend error"
+	ProviderError    Code = "provider error"
+	InternalError    Code = "internal error"
+	Timeout          Code = "timeout"
 )
+
+// Sentinel errors for each code. errors.Is reports true for any error with
+// the same code.
+var (
+	ErrNotFound         = &Error{Code: NotFound}
+	ErrPermissionDenied = &Error{Code: PermissionDenied}
+	ErrInvalidProvider  = &Error{Code: InvalidProvider}
+	ErrInvalidArgument  = &Error{Code: InvalidArgument}
+	ErrBackendError     = &Error{Code: BackendError}
+	ErrProviderError    = &Error{Code: ProviderError}
+	ErrInternalError    = &Error{Code: InternalError}
+	ErrTimeout          = &Error{Code: Timeout}
+)
+
+// Error is an error with a code.
+type Error struct {
+	Code Code
+	// Msg describes the error. If empty, the code is used instead.
+	Msg string
+	// Err is the wrapped error (optional).
+	Err error
+}
+
+// New returns an error with the given code and message.
+func New(code Code, msg string) error {
+	return &Error{Code: code, Msg: msg}
+}
+
+// Wrap returns an error with the given code which wraps err. It returns nil
+// if err is nil.
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Err: err}
+}
+
+func (e *Error) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = string(e.Code)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is the sentinel error of the code of e.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Msg == "" && t.Err == nil && t.Code == e.Code
+}
+
+// CodeOf returns the code of the first error with a code in the chain of err.
+// Context errors have the Timeout code. It returns an empty code if there is
+// none.
+func CodeOf(err error) Code {
+	var e *Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return Timeout
+	}
+	return ""
+}
+
+// FromHTTPStatus returns the code for an HTTP status returned by a backend.
+func FromHTTPStatus(statusCode int) Code {
+	switch {
+	case statusCode == http.StatusNotFound:
+		return NotFound
+	case statusCode == http.StatusUnauthorized, statusCode == http.StatusForbidden:
+		return PermissionDenied
+	case statusCode == http.StatusTooManyRequests, statusCode >= 500:
+		return BackendError
+	case statusCode >= 400:
+		return InvalidArgument
+	}
+	return ProviderError
+}
+
+// FromGRPC wraps an error returned by a gRPC backend with the code for its
+// status. It returns nil if err is nil.
+func FromGRPC(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		return Wrap(NotFound, err)
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return Wrap(PermissionDenied, err)
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return Wrap(InvalidArgument, err)
+	case codes.Unavailable, codes.ResourceExhausted, codes.Internal, codes.Aborted, codes.DataLoss:
+		return Wrap(BackendError, err)
+	case codes.DeadlineExceeded, codes.Canceled:
+		return Wrap(Timeout, err)
+	}
+	return Wrap(ProviderError, err)
+}
@@ -0,0 +1,67 @@
+package errors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrors(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Errors")
+}
+
+var _ = Describe("Error", func() {
+	It("should match the sentinel error of its code", func() {
+		err := fmt.Errorf("reading secret: %w", Wrap(NotFound, errors.New("no such secret")))
+		Expect(err).To(MatchError(ErrNotFound))
+		Expect(err).ToNot(MatchError(ErrPermissionDenied))
+		Expect(err.Error()).To(Equal("reading secret: not found: no such secret"))
+		Expect(CodeOf(err)).To(Equal(NotFound))
+
+		err = New(InvalidArgument, "invalid key")
+		Expect(err).To(MatchError(ErrInvalidArgument))
+		Expect(err.Error()).To(Equal("invalid key"))
+	})
+
+	It("should return the code of an error", func() {
+		Expect(CodeOf(nil)).To(BeEmpty())
+		Expect(CodeOf(errors.New("x"))).To(BeEmpty())
+		Expect(CodeOf(fmt.Errorf("x: %w", context.DeadlineExceeded))).To(Equal(Timeout))
+		Expect(Wrap(NotFound, nil)).To(BeNil())
+	})
+
+	DescribeTable("FromHTTPStatus",
+		func(statusCode int, expected Code) {
+			Expect(FromHTTPStatus(statusCode)).To(Equal(expected))
+		},
+		Entry(nil, http.StatusNotFound, NotFound),
+		Entry(nil, http.StatusForbidden, PermissionDenied),
+		Entry(nil, http.StatusUnauthorized, PermissionDenied),
+		Entry(nil, http.StatusBadRequest, InvalidArgument),
+		Entry(nil, http.StatusTooManyRequests, BackendError),
+		Entry(nil, http.StatusBadGateway, BackendError),
+	)
+
+	DescribeTable("FromGRPC",
+		func(code codes.Code, expected Code) {
+			err := FromGRPC(status.Error(code, "x"))
+			Expect(CodeOf(err)).To(Equal(expected))
+			Expect(status.Code(errors.Unwrap(err))).To(Equal(code))
+		},
+		Entry(nil, codes.NotFound, NotFound),
+		Entry(nil, codes.PermissionDenied, PermissionDenied),
+		Entry(nil, codes.Unauthenticated, PermissionDenied),
+		Entry(nil, codes.InvalidArgument, InvalidArgument),
+		Entry(nil, codes.Unavailable, BackendError),
+		Entry(nil, codes.DeadlineExceeded, Timeout),
+		Entry(nil, codes.Unknown, ProviderError),
+	)
+})
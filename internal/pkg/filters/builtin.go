@@ -0,0 +1,211 @@
+package filters
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Built-in Filters
+
+// Base64Encode encodes the value with standard base64 encoding.
+func Base64Encode(value string, args ...string) (string, error) {
+	if err := expectArgs("b64enc", args, 0); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString([]byte(value)), nil
+}
+
+// Base64Decode decodes a standard or url-safe base64 encoded value.
+func Base64Decode(value string, args ...string) (string, error) {
+	if err := expectArgs("b64dec", args, 0); err != nil {
+		return "", err
+	}
+
+	value = strings.TrimSpace(value)
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding,
+		base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		if b, err := enc.DecodeString(value); err == nil {
+			return string(b), nil
+		}
+	}
+	return "", fmt.Errorf("b64dec: value is not valid base64")
+}
+
+// Trim removes leading and trailing whitespace.
+func Trim(value string, args ...string) (string, error) {
+	if err := expectArgs("trim", args, 0); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// Upper converts the value to upper case.
+func Upper(value string, args ...string) (string, error) {
+	if err := expectArgs("upper", args, 0); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(value), nil
+}
+
+// Lower converts the value to lower case.
+func Lower(value string, args ...string) (string, error) {
+	if err := expectArgs("lower", args, 0); err != nil {
+		return "", err
+	}
+	return strings.ToLower(value), nil
+}
+
+// JSONEscape escapes the value for use inside of a JSON string. The
+// surrounding quotes are not included.
+func JSONEscape(value string, args ...string) (string, error) {
+	if err := expectArgs("jsonescape", args, 0); err != nil {
+		return "", err
+	}
+
+	quoted, err := quoteJSON(value)
+	if err != nil {
+		return "", err
+	}
+	return quoted[1 : len(quoted)-1], nil
+}
+
+// YAMLQuote converts the value to a double-quoted YAML scalar.
+func YAMLQuote(value string, args ...string) (string, error) {
+	if err := expectArgs("yamlquote", args, 0); err != nil {
+		return "", err
+	}
+	// a JSON string is also a valid double-quoted YAML scalar
+	return quoteJSON(value)
+}
+
+// SHA256 returns the hex encoded sha256 digest of the value.
+func SHA256(value string, args ...string) (string, error) {
+	if err := expectArgs("sha256", args, 0); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// URLEncode escapes the value for use in a URL query.
+func URLEncode(value string, args ...string) (string, error) {
+	if err := expectArgs("urlencode", args, 0); err != nil {
+		return "", err
+	}
+	return url.QueryEscape(value), nil
+}
+
+// maxBcryptCost limits the cost of bcrypt hashes since the time to compute
+// them grows exponentially and replacements run in admission requests. A hash
+// of cost 12 takes about 250ms.
+const maxBcryptCost = 12
+
+// Bcrypt hashes the value with bcrypt. An optional cost may be given as
+// the first argument.
+func Bcrypt(value string, args ...string) (string, error) {
+	if err := expectArgs("bcrypt", args, 0, 1); err != nil {
+		return "", err
+	}
+
+	cost := bcrypt.DefaultCost
+	if len(args) == 1 {
+		var err error
+		cost, err = strconv.Atoi(args[0])
+		if err != nil || cost < bcrypt.MinCost || cost > maxBcryptCost {
+			return "", fmt.Errorf("bcrypt: invalid cost %q", args[0])
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(value), cost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt: %v", err)
+	}
+	return string(hash), nil
+}
+
+// Htpasswd returns an htpasswd entry for the user given as the first argument
+// with the value as a bcrypt hashed password.
+func Htpasswd(value string, args ...string) (string, error) {
+	if err := expectArgs("htpasswd", args, 1); err != nil {
+		return "", err
+	} else if args[0] == "" || strings.ContainsAny(args[0], ":\n") {
+		return "", fmt.Errorf("htpasswd: invalid user %q", args[0])
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(value), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("htpasswd: %v", err)
+	}
+	// htpasswd uses the $2y$ prefix for bcrypt hashes
+	return args[0] + ":$2y$" + strings.TrimPrefix(string(hash), "$2a$"), nil
+}
+
+// Indent indents every line after the first by the number of spaces given as
+// the first argument. The first line is not indented since the tag itself is
+// usually already indented in the template.
+func Indent(value string, args ...string) (string, error) {
+	if err := expectArgs("indent", args, 1); err != nil {
+		return "", err
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("indent: invalid width %q", args[0])
+	}
+
+	pad := strings.Repeat(" ", n)
+	return strings.ReplaceAll(value, "\n", "\n"+pad), nil
+}
+
+//
+
+func quoteJSON(value string) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func expectArgs(name string, args []string, counts ...int) error {
+	for _, n := range counts {
+		if len(args) == n {
+			return nil
+		}
+	}
+
+	if len(counts) == 1 {
+		return fmt.Errorf("%s: expected %d argument(s), got %d", name, counts[0], len(args))
+	}
+	return fmt.Errorf("%s: expected %d to %d arguments, got %d", name, counts[0], counts[len(counts)-1], len(args))
+}
+
+func init() {
+	// register the built-in filters
+	Register("b64enc", Base64Encode)
+	Register("b64dec", Base64Decode)
+	Register("trim", Trim)
+	Register("upper", Upper)
+	Register("lower", Lower)
+	Register("jsonescape", JSONEscape)
+	Register("yamlquote", YAMLQuote)
+	Register("sha256", SHA256)
+	Register("urlencode", URLEncode)
+	Register("bcrypt", Bcrypt)
+	Register("htpasswd", Htpasswd)
+	Register("indent", Indent)
+}
@@ -0,0 +1,37 @@
+package filters
+
+import (
+	"errors"
+)
+
+var (
+	filters = make(map[string]Filter)
+)
+
+// Filter transforms a replacement value. The args are the arguments given to the
+// filter in the template (e.g. "4" in <replace:key | indent 4>).
+type Filter func(value string, args ...string) (string, error)
+
+// Register associates a name with a filter. It should be called from the init
+// function of the filter's package. This function panics if a filter with the
+// same name is already registered.
+func Register(name string, filter Filter) {
+	if _, ok := filters[name]; ok {
+		panic("filter already registered: " + name)
+	}
+
+	filters[name] = filter
+}
+
+// Get returns the filter with the given name. It returns an error if no such
+// filter is registered.
+func Get(name string) (Filter, error) {
+	f, ok := filters[name]
+	if !ok {
+		if name == "" {
+			return nil, errors.New("no filter given")
+		}
+		return nil, errors.New("filter not found: " + name)
+	}
+	return f, nil
+}
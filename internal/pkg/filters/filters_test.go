@@ -0,0 +1,100 @@
+package filters
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFilters(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Filters Suite")
+}
+
+var _ = Describe("Filters", func() {
+	DescribeTable("built-in filters",
+		func(name string, value string, args []string, expected string) {
+			f, err := Get(name)
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := f(value, args...)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal(expected))
+		},
+		Entry(nil, "b64enc", "hello", nil, "aGVsbG8="),
+		Entry(nil, "b64dec", "aGVsbG8=", nil, "hello"),
+		Entry(nil, "b64dec", "aGVsbG8\n", nil, "hello"),
+		Entry(nil, "trim", "  hello \n", nil, "hello"),
+		Entry(nil, "upper", "Hello", nil, "HELLO"),
+		Entry(nil, "lower", "Hello", nil, "hello"),
+		Entry(nil, "jsonescape", "a \"quoted\"\n<value>", nil, `a \"quoted\"\n<value>`),
+		Entry(nil, "yamlquote", "key: value\n", nil, `"key: value\n"`),
+		Entry(nil, "sha256", "hello", nil, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"),
+		Entry(nil, "urlencode", "p@ss word&", nil, "p%40ss+word%26"),
+		Entry(nil, "indent", "line1\nline2\nline3", []string{"2"}, "line1\n  line2\n  line3"),
+	)
+
+	DescribeTable("invalid arguments",
+		func(name string, args []string) {
+			f, err := Get(name)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = f("value", args...)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry(nil, "b64enc", []string{"extra"}),
+		Entry(nil, "indent", nil),
+		Entry(nil, "indent", []string{"four"}),
+		Entry(nil, "bcrypt", []string{"100"}),
+		Entry(nil, "bcrypt", []string{"13"}),
+		Entry(nil, "htpasswd", nil),
+		Entry(nil, "htpasswd", []string{"user:name"}),
+	)
+
+	It("should return an error for invalid base64", func() {
+		_, err := Base64Decode("not base64!")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should hash values with bcrypt", func() {
+		hash, err := Bcrypt("hunter2", "4")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bcrypt.CompareHashAndPassword([]byte(hash), []byte("hunter2"))).To(Succeed())
+	})
+
+	It("should create htpasswd entries", func() {
+		entry, err := Htpasswd("hunter2", "admin")
+		Expect(err).ToNot(HaveOccurred())
+
+		parts := strings.SplitN(entry, ":", 2)
+		Expect(parts[0]).To(Equal("admin"))
+		Expect(parts[1]).To(HavePrefix("$2y$"))
+
+		hash := "$2a$" + strings.TrimPrefix(parts[1], "$2y$")
+		Expect(bcrypt.CompareHashAndPassword([]byte(hash), []byte("hunter2"))).To(Succeed())
+	})
+
+	It("should register custom filters", func() {
+		Register("test-reverse", func(value string, args ...string) (string, error) {
+			runes := []rune(value)
+			for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+			}
+			return string(runes), nil
+		})
+
+		f, err := Get("test-reverse")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f("abc")).To(Equal("cba"))
+		Expect(func() { Register("test-reverse", Trim) }).To(Panic())
+	})
+
+	It("should return an error for unknown filters", func() {
+		_, err := Get("unknown")
+		Expect(err).To(HaveOccurred())
+	})
+})
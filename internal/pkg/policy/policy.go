@@ -0,0 +1,193 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aar10n/replacer/api/v1alpha1"
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/replacer"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// serviceAccountPrefix is the prefix of the user names of service accounts.
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// Subject is the requester of a replacement and the namespace of the
+// resource being replaced.
+type Subject struct {
+	Namespace string
+	// User is the name of the requesting user. It is empty for replacements
+	// made by the controllers, which only match policies without subjects.
+	User   string
+	Groups []string
+}
+
+func (s Subject) String() string {
+	if s.User == "" {
+		return fmt.Sprintf("the controller in namespace %s", s.Namespace)
+	}
+	return fmt.Sprintf("user %s in namespace %s", s.User, s.Namespace)
+}
+
+// Set is a set of policies.
+type Set []v1alpha1.ReplacerPolicy
+
+// List returns all policies, ordered by name.
+func List(ctx context.Context, c client.Reader) (Set, error) {
+	list := &v1alpha1.ReplacerPolicyList{}
+	if err := c.List(ctx, list); err != nil {
+		return nil, ierrors.Wrap(ierrors.BackendError, fmt.Errorf("failed to list policies: %w", err))
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Name < list.Items[j].Name
+	})
+	return list.Items, nil
+}
+
+// Authorizer returns the function checking the keys read for subject. Keys
+// are allowed if any policy applying to subject allows them. Without any
+// policies all keys are allowed, and nil is returned.
+func (s Set) Authorizer(subject Subject) replacer.AuthorizeFunc {
+	if len(s) == 0 {
+		return nil
+	}
+
+	var names []string
+	var applied []*v1alpha1.ReplacerPolicy
+	for i := range s {
+		if appliesTo(&s[i], subject) {
+			names = append(names, s[i].Name)
+			applied = append(applied, &s[i])
+		}
+	}
+
+	return func(provider string, config map[string]string, key string) error {
+		for _, p := range applied {
+			if allows(p, provider, config, key) {
+				return nil
+			}
+		}
+
+		if len(applied) == 0 {
+			return ierrors.New(ierrors.PermissionDenied, fmt.Sprintf("no ReplacerPolicy applies to %s", subject))
+		} else if len(config) > 0 {
+			return ierrors.New(ierrors.PermissionDenied, fmt.Sprintf(
+				"key %s of provider %s with options %s is not allowed for %s by ReplacerPolicy %s",
+				key, provider, formatConfig(config), subject, strings.Join(names, ", "),
+			))
+		}
+		return ierrors.New(ierrors.PermissionDenied, fmt.Sprintf(
+			"key %s of provider %s is not allowed for %s by ReplacerPolicy %s",
+			key, provider, subject, strings.Join(names, ", "),
+		))
+	}
+}
+
+// appliesTo returns true if a policy applies to subject.
+func appliesTo(p *v1alpha1.ReplacerPolicy, subject Subject) bool {
+	if len(p.Spec.Namespaces) > 0 && !matchAny(p.Spec.Namespaces, subject.Namespace) {
+		return false
+	} else if len(p.Spec.Subjects) == 0 {
+		return true
+	} else if subject.User == "" {
+		return false
+	}
+
+	for _, s := range p.Spec.Subjects {
+		switch s.Kind {
+		case v1alpha1.UserKind:
+			if match(s.Name, subject.User) {
+				return true
+			}
+		case v1alpha1.GroupKind:
+			for _, g := range subject.Groups {
+				if match(s.Name, g) {
+					return true
+				}
+			}
+		case v1alpha1.ServiceAccountKind:
+			if !strings.HasPrefix(subject.User, serviceAccountPrefix) {
+				continue
+			}
+			namespace, name, _ := strings.Cut(strings.TrimPrefix(subject.User, serviceAccountPrefix), ":")
+			ns := s.Namespace
+			if ns == "" {
+				ns = subject.Namespace
+			}
+			if match(ns, namespace) && match(s.Name, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allows returns true if any rule of a policy allows a key of a provider with
+// the options set by the resource.
+func allows(p *v1alpha1.ReplacerPolicy, provider string, config map[string]string, key string) bool {
+	for _, rule := range p.Spec.Rules {
+		if !matchAny(rule.Providers, provider) || !allowsConfig(rule.Config, config) {
+			continue
+		}
+		if len(rule.Keys) == 0 || matchAny(rule.Keys, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsConfig returns true if every option in config is allowed.
+func allowsConfig(allowed map[string]string, config map[string]string) bool {
+	for k, v := range config {
+		pattern, ok := allowed[k]
+		if !ok || !match(pattern, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// formatConfig formats provider options as a sorted list of key=value pairs.
+func formatConfig(config map[string]string) string {
+	pairs := make([]string, 0, len(config))
+	for k, v := range config {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if match(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns true if s matches pattern, in which * matches any sequence of
+// characters.
+func match(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	} else if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aar10n/replacer/api/v1alpha1"
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPolicy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Policy Suite")
+}
+
+func newPolicy(name string, spec v1alpha1.ReplacerPolicySpec) v1alpha1.ReplacerPolicy {
+	return v1alpha1.ReplacerPolicy{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+}
+
+var _ = Describe("Policy", func() {
+	DescribeTable("match",
+		func(pattern string, s string, expected bool) {
+			Expect(match(pattern, s)).To(Equal(expected))
+		},
+		Entry(nil, "app", "app", true),
+		Entry(nil, "app", "app2", false),
+		Entry(nil, "*", "", true),
+		Entry(nil, "*", "my-project/db", true),
+		Entry(nil, "my-project/*", "my-project/db/password", true),
+		Entry(nil, "my-project/*", "other/db", false),
+		Entry(nil, "*-password", "db-password", true),
+		Entry(nil, "*-password", "db-password-old", false),
+		Entry(nil, "team-*-prod", "team-a-prod", true),
+		Entry(nil, "a*b*c", "abbc", true),
+		Entry(nil, "a*b*c", "acb", false),
+		Entry(nil, "a*a", "a", false),
+	)
+
+	Describe("Authorizer", func() {
+		policies := Set{
+			newPolicy("payments", v1alpha1.ReplacerPolicySpec{
+				Namespaces: []string{"payments", "payments-*"},
+				Rules: []v1alpha1.PolicyRule{
+					{Providers: []string{"gcp"}, Keys: []string{"payments-prod/*"}},
+					{Providers: []string{"k8s"}},
+					{Providers: []string{"vault"}, Keys: []string{"secret/payments/*"}, Config: map[string]string{
+						"namespace": "payments", "mount": "kv-*",
+					}},
+				},
+			}),
+			newPolicy("ci", v1alpha1.ReplacerPolicySpec{
+				Subjects: []v1alpha1.PolicySubject{
+					{Kind: v1alpha1.ServiceAccountKind, Name: "deployer"},
+					{Kind: v1alpha1.GroupKind, Name: "platform-*"},
+				},
+				Rules: []v1alpha1.PolicyRule{
+					{Providers: []string{"*"}, Keys: []string{"ci/*"}},
+				},
+			}),
+			newPolicy("ops", v1alpha1.ReplacerPolicySpec{
+				Namespaces: []string{"ops"},
+				Subjects: []v1alpha1.PolicySubject{
+					{Kind: v1alpha1.UserKind, Name: "alice@example.com"},
+					{Kind: v1alpha1.ServiceAccountKind, Name: "*", Namespace: "kube-system"},
+				},
+				Rules: []v1alpha1.PolicyRule{
+					{Providers: []string{"vault"}},
+				},
+			}),
+		}
+
+		DescribeTable("should allow keys allowed by a policy applying to the subject",
+			func(subject Subject, provider string, key string, allowed bool) {
+				err := policies.Authorizer(subject)(provider, nil, key)
+				if allowed {
+					Expect(err).ToNot(HaveOccurred())
+				} else {
+					Expect(errors.Is(err, ierrors.ErrPermissionDenied)).To(BeTrue(), "%v", err)
+				}
+			},
+			Entry("namespace", Subject{Namespace: "payments"}, "gcp", "payments-prod/db", true),
+			Entry("namespace pattern", Subject{Namespace: "payments-eu", User: "bob"}, "k8s", "secret/x", true),
+			Entry("other key", Subject{Namespace: "payments"}, "gcp", "other/db", false),
+			Entry("other provider", Subject{Namespace: "payments"}, "aws", "payments-prod/db", false),
+			Entry("other namespace", Subject{Namespace: "default"}, "gcp", "payments-prod/db", false),
+			Entry("service account", Subject{Namespace: "apps", User: "system:serviceaccount:apps:deployer"}, "aws", "ci/token", true),
+			Entry("service account of another namespace", Subject{Namespace: "apps", User: "system:serviceaccount:other:deployer"}, "aws", "ci/token", false),
+			Entry("group", Subject{Namespace: "apps", User: "carol", Groups: []string{"platform-admins"}}, "gcp", "ci/token", true),
+			Entry("controller", Subject{Namespace: "apps"}, "gcp", "ci/token", false),
+			Entry("user", Subject{Namespace: "ops", User: "alice@example.com"}, "vault", "secret/ops", true),
+			Entry("user in another namespace", Subject{Namespace: "apps", User: "alice@example.com"}, "vault", "secret/ops", false),
+			Entry("service account with namespace", Subject{Namespace: "ops", User: "system:serviceaccount:kube-system:x"}, "vault", "secret/ops", true),
+		)
+
+		DescribeTable("should only allow provider options listed by a rule",
+			func(config map[string]string, allowed bool) {
+				err := policies.Authorizer(Subject{Namespace: "payments"})("vault", config, "secret/payments/db")
+				if allowed {
+					Expect(err).ToNot(HaveOccurred())
+				} else {
+					Expect(errors.Is(err, ierrors.ErrPermissionDenied)).To(BeTrue(), "%v", err)
+				}
+			},
+			Entry("no options", nil, true),
+			Entry("allowed options", map[string]string{"namespace": "payments", "mount": "kv-v2"}, true),
+			Entry("other value", map[string]string{"namespace": "team-b"}, false),
+			Entry("other pattern value", map[string]string{"mount": "secret"}, false),
+			Entry("unlisted option", map[string]string{"address": "https://vault.example.com"}, false),
+		)
+
+		It("should deny provider options of rules without options", func() {
+			authorize := policies.Authorizer(Subject{Namespace: "payments"})
+			Expect(authorize("gcp", nil, "payments-prod/db")).To(Succeed())
+
+			err := authorize("gcp", map[string]string{"project_id": "other"}, "payments-prod/db")
+			Expect(err).To(MatchError(
+				"key payments-prod/db of provider gcp with options project_id=other is not allowed " +
+					"for the controller in namespace payments by ReplacerPolicy payments",
+			))
+		})
+
+		It("should allow all keys without policies", func() {
+			Expect(Set{}.Authorizer(Subject{Namespace: "default"})).To(BeNil())
+		})
+
+		It("should give the reason of a denial", func() {
+			err := policies.Authorizer(Subject{Namespace: "default", User: "bob"})("gcp", nil, "db")
+			Expect(err).To(MatchError("no ReplacerPolicy applies to user bob in namespace default"))
+
+			err = policies.Authorizer(Subject{Namespace: "payments"})("gcp", nil, "db")
+			Expect(err).To(MatchError(
+				"key db of provider gcp is not allowed for the controller in namespace payments by ReplacerPolicy payments",
+			))
+		})
+	})
+
+	Describe("List", func() {
+		It("should list the policies by name", func() {
+			scheme := runtime.NewScheme()
+			Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+
+			b, a := newPolicy("b", v1alpha1.ReplacerPolicySpec{}), newPolicy("a", v1alpha1.ReplacerPolicySpec{})
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&b, &a).Build()
+
+			policies, err := List(context.Background(), c)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policies).To(HaveLen(2))
+			Expect(policies[0].Name).To(Equal("a"))
+			Expect(policies[1].Name).To(Equal("b"))
+		})
+	})
+})
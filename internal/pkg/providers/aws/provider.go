@@ -0,0 +1,324 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/providers"
+	"github.com/aar10n/replacer/pkg/aws"
+	"github.com/aar10n/replacer/pkg/cache"
+)
+
+// AWS Secrets Manager Provider
+
+var (
+	arnPattern  = regexp.MustCompile(`^arn:[\w-]+:secretsmanager:([\w-]+):(\d+):secret:([\w/+=.@-]+)(?::([\w-]+))?$`)
+	namePattern = regexp.MustCompile(`^([\w/+=.@-]+)(?::([\w-]+))?$`)
+)
+
+type SecretsManagerProvider struct {
+	creds   aws.CredentialsProvider
+	cache   *cache.Cache
+	clients map[string]*aws.SecretsManagerClient
+	lock    sync.Mutex
+
+	// allowedEndpoints is taken from the environment of the webhook
+	allowedEndpoints []string
+
+	Region   string `config:"region"`
+	Endpoint string `config:"endpoint"`
+}
+
+type secretRef struct {
+	region       string
+	secretID     string
+	versionStage string
+	field        string
+}
+
+func SecretsManagerProviderFactory() (providers.ValueProvider, error) {
+	creds, err := aws.DefaultCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &SecretsManagerProvider{
+		creds:            creds,
+		cache:            cache.New(),
+		clients:          make(map[string]*aws.SecretsManagerClient),
+		allowedEndpoints: splitList(os.Getenv("AWS_ALLOWED_ENDPOINTS")),
+	}
+	return p, nil
+}
+
+func (p *SecretsManagerProvider) ValueFor(key string) (string, error) {
+	return p.ValueForContext(context.Background(), key)
+}
+
+func (p *SecretsManagerProvider) ValueForContext(ctx context.Context, key string) (string, error) {
+	ref, err := p.parseSecretRef(key)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := p.getSecretValue(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+
+	if ref.field == "" {
+		return value, nil
+	}
+	return extractField(value, ref.field)
+}
+
+// ValuesFor fetches the current versions of the secrets referenced by keys
+// with BatchGetSecretValue. Keys with a version stage are fetched one at a time.
+func (p *SecretsManagerProvider) ValuesFor(ctx context.Context, keys []string) (map[string]string, error) {
+	refs := make(map[string]*secretRef, len(keys))
+	batches := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		ref, err := p.parseSecretRef(key)
+		if err != nil {
+			return nil, err
+		}
+		refs[key] = ref
+
+		cacheKey := ref.cacheKey()
+		if ref.versionStage != "" || seen[cacheKey] || p.cache.Get(cacheKey) != nil {
+			continue
+		}
+		seen[cacheKey] = true
+		batches[ref.region] = append(batches[ref.region], ref.secretID)
+	}
+
+	missing := make(map[string]bool)
+	for region, ids := range batches {
+		err := p.batchGetSecretValues(ctx, region, ids, missing)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		ref := refs[key]
+		if missing[ref.cacheKey()] {
+			continue
+		}
+
+		value, err := p.getSecretValue(ctx, ref)
+		if err == nil && ref.field != "" {
+			value, err = extractField(value, ref.field)
+		}
+
+		if errors.Is(err, providers.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// batchGetSecretValues fetches the given secrets of a region and caches them.
+// Secrets which do not exist are added to missing.
+func (p *SecretsManagerProvider) batchGetSecretValues(ctx context.Context, region string, ids []string, missing map[string]bool) error {
+	client, err := p.getClient(region)
+	if err != nil {
+		return err
+	}
+
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > aws.MaxBatchSize {
+			n = aws.MaxBatchSize
+		}
+
+		res, err := client.BatchGetSecretValue(ctx, ids[:n])
+		if err != nil {
+			return wrapError(err)
+		}
+
+		for _, id := range ids[:n] {
+			ref := &secretRef{region: region, secretID: id}
+			if value, ok := res.Values[id]; ok {
+				p.cache.Set(ref.cacheKey(), value)
+			} else if e, ok := res.Errors[id]; ok && e.Code == "ResourceNotFoundException" {
+				missing[ref.cacheKey()] = true
+			} else if ok {
+				return wrapError(e)
+			}
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+func (p *SecretsManagerProvider) getSecretValue(ctx context.Context, ref *secretRef) (string, error) {
+	cacheKey := ref.cacheKey()
+	if value := p.cache.Get(cacheKey); value != nil {
+		return value.(string), nil
+	}
+
+	client, err := p.getClient(ref.region)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := client.GetSecretValue(ctx, ref.secretID, ref.versionStage)
+	if err != nil {
+		return "", wrapError(err)
+	}
+
+	p.cache.Set(cacheKey, value)
+	return value, nil
+}
+
+func (p *SecretsManagerProvider) getClient(region string) (*aws.SecretsManagerClient, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if client, ok := p.clients[region]; ok {
+		return client, nil
+	}
+
+	endpoint, err := p.getEndpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := aws.NewSecretsManagerClient(aws.Config{
+		Region:      region,
+		Endpoint:    endpoint,
+		Credentials: p.creds,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.clients[region] = client
+	return client, nil
+}
+
+// getEndpoint returns the endpoint given in the config, which must be listed
+// in AWS_ALLOWED_ENDPOINTS since signed requests with the credentials of the
+// webhook are sent to it. An empty endpoint selects the regional endpoint.
+func (p *SecretsManagerProvider) getEndpoint() (string, error) {
+	if p.Endpoint == "" {
+		return "", nil
+	}
+
+	for _, endpoint := range p.allowedEndpoints {
+		if p.Endpoint == endpoint {
+			return p.Endpoint, nil
+		}
+	}
+	return "", ierrors.New(ierrors.PermissionDenied, "aws endpoint not allowed: "+p.Endpoint)
+}
+
+// parseSecretRef parses a key of one of the following forms:
+//
+//	<name>[:<version-stage>][#<json-field>]
+//	arn:aws:secretsmanager:<region>:<account>:secret:<name>[:<version-stage>][#<json-field>]
+func (p *SecretsManagerProvider) parseSecretRef(key string) (*secretRef, error) {
+	key = strings.Trim(key, " \t")
+
+	ref := &secretRef{region: p.Region}
+	if i := strings.Index(key, "#"); i >= 0 {
+		ref.field = key[i+1:]
+		key = key[:i]
+		if ref.field == "" {
+			return nil, ierrors.New(ierrors.InvalidArgument, "empty json field in key: "+key)
+		}
+	}
+
+	if res := arnPattern.FindStringSubmatch(key); res != nil {
+		ref.region = res[1]
+		ref.versionStage = res[4]
+		ref.secretID = strings.TrimSuffix(key, ":"+res[4])
+		return ref, nil
+	} else if res = namePattern.FindStringSubmatch(key); res != nil {
+		ref.secretID = res[1]
+		ref.versionStage = res[2]
+		return ref, nil
+	}
+	return nil, ierrors.New(ierrors.InvalidArgument, "invalid secret key: "+key)
+}
+
+func (ref *secretRef) cacheKey() string {
+	return ref.region + "/" + ref.secretID + ":" + ref.versionStage
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func extractField(value string, field string) (string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", ierrors.New(ierrors.InvalidArgument, "secret is not a json object: "+err.Error())
+	}
+
+	v, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("%w: field %s in secret", providers.ErrNotFound, field)
+	}
+
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// wrapError adds the code for an error returned by secrets manager. Requests
+// that fail to reach the service are backend errors.
+func wrapError(err error) error {
+	var awsErr *aws.Error
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && !urlErr.Timeout() {
+		return ierrors.Wrap(ierrors.BackendError, err)
+	} else if !errors.As(err, &awsErr) {
+		return err
+	}
+
+	switch awsErr.Code {
+	case "ResourceNotFoundException":
+		return ierrors.Wrap(ierrors.NotFound, err)
+	case "AccessDeniedException", "UnrecognizedClientException", "InvalidSignatureException",
+		"ExpiredTokenException", "DecryptionFailure":
+		return ierrors.Wrap(ierrors.PermissionDenied, err)
+	case "InvalidParameterException", "InvalidRequestException":
+		return ierrors.Wrap(ierrors.InvalidArgument, err)
+	case "ThrottlingException", "InternalServiceError":
+		return ierrors.Wrap(ierrors.BackendError, err)
+	}
+	return ierrors.Wrap(ierrors.FromHTTPStatus(awsErr.StatusCode), err)
+}
+
+//
+
+func init() {
+	// register the provider
+	providers.Register("aws", SecretsManagerProviderFactory)
+}
@@ -0,0 +1,258 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aar10n/replacer/internal/pkg/providers"
+	"github.com/aar10n/replacer/pkg/aws"
+	"github.com/aar10n/replacer/pkg/cache"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestProvider(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "AWS Provider Suite")
+}
+
+type fakeSecret struct {
+	versionStage string
+	value        string
+}
+
+// newFakeSecretsManager returns a server that implements GetSecretValue and
+// BatchGetSecretValue for the given secrets.
+func newFakeSecretsManager(secrets map[string][]fakeSecret, requests *int) *httptest.Server {
+	lookup := func(id string, versionStage string) (string, bool) {
+		if versionStage == "" {
+			versionStage = "AWSCURRENT"
+		}
+		for _, s := range secrets[id] {
+			if s.versionStage == versionStage {
+				return s.value, true
+			}
+		}
+		return "", false
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "secretsmanager.GetSecretValue":
+			var in struct {
+				SecretId     string
+				VersionStage string
+			}
+			_ = json.NewDecoder(r.Body).Decode(&in)
+
+			if value, ok := lookup(in.SecretId, in.VersionStage); ok {
+				_ = json.NewEncoder(w).Encode(map[string]string{
+					"Name":         in.SecretId,
+					"SecretString": value,
+				})
+				return
+			}
+
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"Secrets Manager can't find the specified secret."}`))
+		case "secretsmanager.BatchGetSecretValue":
+			var in struct {
+				SecretIdList []string
+			}
+			_ = json.NewDecoder(r.Body).Decode(&in)
+
+			values := []map[string]string{}
+			errs := []map[string]string{}
+			for _, id := range in.SecretIdList {
+				if value, ok := lookup(id, ""); ok {
+					values = append(values, map[string]string{
+						"ARN":          "arn:aws:secretsmanager:us-east-1:123456789012:secret:" + id + "-AbCdEf",
+						"Name":         id,
+						"SecretString": value,
+					})
+				} else {
+					errs = append(errs, map[string]string{
+						"SecretId":     id,
+						"ErrorCode":    "ResourceNotFoundException",
+						"ErrorMessage": "Secrets Manager can't find the specified secret.",
+					})
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"SecretValues": values,
+				"Errors":       errs,
+			})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+var _ = Describe("AWS Provider", func() {
+	Describe("parseSecretRef", func() {
+		It("should parse a secret name", func() {
+			provider := &SecretsManagerProvider{Region: "us-east-1"}
+
+			ref, err := provider.parseSecretRef("my-app/db")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*ref).To(Equal(secretRef{region: "us-east-1", secretID: "my-app/db"}))
+		})
+		It("should parse a secret name with a version stage and field", func() {
+			provider := &SecretsManagerProvider{Region: "us-east-1"}
+
+			ref, err := provider.parseSecretRef("my-app/db:AWSPREVIOUS#password")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*ref).To(Equal(secretRef{
+				region:       "us-east-1",
+				secretID:     "my-app/db",
+				versionStage: "AWSPREVIOUS",
+				field:        "password",
+			}))
+		})
+		It("should parse an arn and use its region", func() {
+			provider := &SecretsManagerProvider{Region: "us-east-1"}
+			arn := "arn:aws:secretsmanager:eu-west-1:123456789012:secret:my-app/db-AbCdEf"
+
+			ref, err := provider.parseSecretRef(arn)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*ref).To(Equal(secretRef{region: "eu-west-1", secretID: arn}))
+		})
+		It("should parse an arn with a version stage", func() {
+			provider := &SecretsManagerProvider{}
+			arn := "arn:aws:secretsmanager:eu-west-1:123456789012:secret:my-app/db-AbCdEf"
+
+			ref, err := provider.parseSecretRef(arn + ":AWSPENDING#user")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*ref).To(Equal(secretRef{
+				region:       "eu-west-1",
+				secretID:     arn,
+				versionStage: "AWSPENDING",
+				field:        "user",
+			}))
+		})
+		It("should return an error for an invalid key", func() {
+			provider := &SecretsManagerProvider{}
+
+			_, err := provider.parseSecretRef("my secret")
+			Expect(err).To(HaveOccurred())
+			_, err = provider.parseSecretRef("my-secret#")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ValueFor and ValuesFor", func() {
+		var (
+			server   *httptest.Server
+			provider *SecretsManagerProvider
+			requests int
+		)
+
+		BeforeEach(func() {
+			requests = 0
+			server = newFakeSecretsManager(map[string][]fakeSecret{
+				"token": {
+					{versionStage: "AWSCURRENT", value: "abc123"},
+					{versionStage: "AWSPREVIOUS", value: "xyz789"},
+				},
+				"my-app/db": {
+					{versionStage: "AWSCURRENT", value: `{"user":"admin","password":"hunter2","port":5432}`},
+				},
+			}, &requests)
+
+			provider = &SecretsManagerProvider{
+				creds:    aws.StaticCredentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"},
+				cache:    cache.New(),
+				clients:  make(map[string]*aws.SecretsManagerClient),
+				Region:   "us-east-1",
+				Endpoint: server.URL,
+
+				allowedEndpoints: []string{server.URL},
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should refuse endpoints that are not allowed", func() {
+			provider.allowedEndpoints = nil
+			_, err := provider.ValueFor("token")
+			Expect(err).To(MatchError(ContainSubstring("aws endpoint not allowed")))
+			Expect(requests).To(Equal(0))
+		})
+		It("should return the current version of a secret", func() {
+			value, err := provider.ValueFor("token")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("abc123"))
+		})
+		It("should return the requested version stage of a secret", func() {
+			value, err := provider.ValueFor("token:AWSPREVIOUS")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("xyz789"))
+		})
+		It("should return a json field of a secret", func() {
+			value, err := provider.ValueFor("my-app/db#password")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("hunter2"))
+
+			value, err = provider.ValueFor("my-app/db#port")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("5432"))
+		})
+		It("should return an error for a missing json field", func() {
+			_, err := provider.ValueFor("my-app/db#missing")
+			Expect(err).To(MatchError(providers.ErrNotFound))
+		})
+		It("should return an error for a missing secret", func() {
+			_, err := provider.ValueFor("missing")
+			Expect(err).To(MatchError(providers.ErrNotFound))
+
+			var awsErr *aws.Error
+			Expect(errors.As(err, &awsErr)).To(BeTrue())
+			Expect(awsErr.Code).To(Equal("ResourceNotFoundException"))
+		})
+		It("should cache secret values", func() {
+			_, err := provider.ValueFor("my-app/db#user")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provider.ValueFor("my-app/db#password")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(requests).To(Equal(1))
+		})
+		It("should fetch many secrets with one request", func() {
+			values, err := provider.ValuesFor(context.Background(), []string{
+				"token",
+				"token:AWSPREVIOUS",
+				"my-app/db#user",
+				"my-app/db#password",
+				"my-app/db#missing",
+				"missing",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(Equal(map[string]string{
+				"token":              "abc123",
+				"token:AWSPREVIOUS":  "xyz789",
+				"my-app/db#user":     "admin",
+				"my-app/db#password": "hunter2",
+			}))
+			// one batch request and one request for the version stage
+			Expect(requests).To(Equal(2))
+
+			value, err := provider.ValueFor("my-app/db#user")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("admin"))
+			Expect(requests).To(Equal(2))
+		})
+	})
+})
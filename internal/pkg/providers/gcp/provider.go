@@ -1,10 +1,12 @@
 package gcp
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
 
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
 	"github.com/aar10n/replacer/internal/pkg/providers"
 	"github.com/aar10n/replacer/pkg/cache"
 	"github.com/aar10n/replacer/pkg/gcp"
@@ -39,6 +41,10 @@ func SecretManagerProviderFactory() (providers.ValueProvider, error) {
 }
 
 func (p *SecretManagerProvider) ValueFor(key string) (string, error) {
+	return p.ValueForContext(context.Background(), key)
+}
+
+func (p *SecretManagerProvider) ValueForContext(ctx context.Context, key string) (string, error) {
 	newKey, err := p.getSecretPath(key)
 	if err != nil {
 		return "", err
@@ -49,9 +55,9 @@ func (p *SecretManagerProvider) ValueFor(key string) (string, error) {
 		return value.(string), nil
 	}
 
-	value, err := p.client.GetSecret(key)
+	value, err := p.client.GetSecret(ctx, key)
 	if err != nil {
-		return "", err
+		return "", ierrors.FromGRPC(err)
 	}
 
 	p.cache.Set(key, value)
@@ -73,13 +79,13 @@ func (p *SecretManagerProvider) getSecretPath(key string) (string, error) {
 	} else if res = shortPathPattern.FindStringSubmatch(key); res != nil {
 		if res[1] == "" {
 			if p.ProjectID == "" {
-				return "", fmt.Errorf("missing project_id in path or config")
+				return "", ierrors.New(ierrors.InvalidArgument, "missing project_id in path or config")
 			}
 			return fmt.Sprintf("projects/%s/secrets/%s/versions/latest", p.ProjectID, res[2]), nil
 		}
 		return fmt.Sprintf("projects/%s/secrets/%s/versions/latest", res[1], res[2]), nil
 	}
-	return "", fmt.Errorf("invalid secret path: %s", key)
+	return "", ierrors.New(ierrors.InvalidArgument, "invalid secret path: "+key)
 }
 
 //
@@ -0,0 +1,279 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/providers"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Kubernetes Secret/ConfigMap Provider
+
+const (
+	// AllowNamespacesAnnotation lists the namespaces (or "*") that may read a source
+	// object when the CrossNamespaceAnnotated policy is used.
+	AllowNamespacesAnnotation = "replacer.agb.dev/allow-namespaces"
+
+	kindSecret    = "secret"
+	kindConfigMap = "configmap"
+)
+
+var (
+	keyPattern = regexp.MustCompile(`^(?:(secret|configmap):)?(?:([a-z0-9.-]+)/)?([a-z0-9.-]+)(?:#([\w.-]+))?$`)
+)
+
+// CrossNamespacePolicy controls whether objects in other namespaces may be read.
+type CrossNamespacePolicy string
+
+const (
+	// CrossNamespaceDeny only allows reading objects in the namespace of the
+	// resource being replaced.
+	CrossNamespaceDeny CrossNamespacePolicy = "deny"
+	// CrossNamespaceAnnotated additionally allows reading objects in other
+	// namespaces if they list the namespace in the allow-namespaces annotation.
+	CrossNamespaceAnnotated CrossNamespacePolicy = "annotated"
+	// CrossNamespaceAllow allows reading objects in any namespace.
+	CrossNamespaceAllow CrossNamespacePolicy = "allow"
+)
+
+// ParseCrossNamespacePolicy returns the policy with the given name.
+func ParseCrossNamespacePolicy(s string) (CrossNamespacePolicy, error) {
+	switch policy := CrossNamespacePolicy(s); policy {
+	case CrossNamespaceDeny, CrossNamespaceAnnotated, CrossNamespaceAllow:
+		return policy, nil
+	case "":
+		return CrossNamespaceDeny, nil
+	}
+	return "", fmt.Errorf("invalid cross-namespace policy: %s", s)
+}
+
+type ObjectProvider struct {
+	client    client.Reader
+	policy    CrossNamespacePolicy
+	namespace string
+}
+
+type objectRef struct {
+	kind      string
+	namespace string
+	name      string
+	key       string
+}
+
+// Register registers the k8s provider using the given client to read objects.
+func Register(c client.Reader, policy CrossNamespacePolicy) {
+	providers.Register("k8s", func() (providers.ValueProvider, error) {
+		return NewObjectProvider(c, policy), nil
+	})
+}
+
+// NewObjectProvider returns a new provider that reads values from Secrets and ConfigMaps.
+func NewObjectProvider(c client.Reader, policy CrossNamespacePolicy) *ObjectProvider {
+	return &ObjectProvider{
+		client: c,
+		policy: policy,
+	}
+}
+
+// SetNamespace sets the namespace of the resource being replaced.
+func (p *ObjectProvider) SetNamespace(namespace string) {
+	p.namespace = namespace
+}
+
+func (p *ObjectProvider) ValueFor(key string) (string, error) {
+	return p.ValueForContext(context.Background(), key)
+}
+
+func (p *ObjectProvider) ValueForContext(ctx context.Context, key string) (string, error) {
+	ref, err := p.parseObjectRef(key)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := p.readObject(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return objectValue(ref, data)
+}
+
+// ValuesFor reads each object referenced by keys once and returns the values
+// of all keys.
+func (p *ObjectProvider) ValuesFor(ctx context.Context, keys []string) (map[string]string, error) {
+	refs := make(map[string]*objectRef, len(keys))
+	for _, key := range keys {
+		ref, err := p.parseObjectRef(key)
+		if err != nil {
+			return nil, err
+		}
+		refs[key] = ref
+	}
+
+	objects := make(map[objectRef]map[string]string)
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		ref := refs[key]
+		object := objectRef{kind: ref.kind, namespace: ref.namespace, name: ref.name}
+
+		data, ok := objects[object]
+		if !ok {
+			var err error
+			data, err = p.readObject(ctx, ref)
+			if err != nil && !errors.Is(err, providers.ErrNotFound) {
+				return nil, err
+			}
+			objects[object] = data
+		}
+		if data == nil {
+			continue
+		}
+
+		value, err := objectValue(ref, data)
+		if errors.Is(err, providers.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// readObject returns the data of the referenced object if it may be read from
+// the namespace of the resource being replaced.
+func (p *ObjectProvider) readObject(ctx context.Context, ref *objectRef) (map[string]string, error) {
+	crossNamespace := ref.namespace != p.namespace && p.policy != CrossNamespaceAllow
+	if crossNamespace && p.policy != CrossNamespaceAnnotated {
+		return nil, p.accessError(ref)
+	}
+
+	var data map[string]string
+	var annotations map[string]string
+	nn := types.NamespacedName{Namespace: ref.namespace, Name: ref.name}
+	switch ref.kind {
+	case kindSecret:
+		secret := &corev1.Secret{}
+		if err := p.client.Get(ctx, nn, secret); err != nil {
+			if crossNamespace {
+				// don't reveal whether objects exist in other namespaces
+				return nil, p.accessError(ref)
+			}
+			return nil, wrapError(err)
+		}
+
+		annotations = secret.Annotations
+		data = make(map[string]string, len(secret.Data))
+		for k, v := range secret.Data {
+			data[k] = string(v)
+		}
+	case kindConfigMap:
+		cm := &corev1.ConfigMap{}
+		if err := p.client.Get(ctx, nn, cm); err != nil {
+			if crossNamespace {
+				return nil, p.accessError(ref)
+			}
+			return nil, wrapError(err)
+		}
+
+		annotations = cm.Annotations
+		data = make(map[string]string, len(cm.Data)+len(cm.BinaryData))
+		for k, v := range cm.BinaryData {
+			data[k] = string(v)
+		}
+		for k, v := range cm.Data {
+			data[k] = v
+		}
+	}
+
+	if crossNamespace && !isNamespaceAllowed(annotations, p.namespace) {
+		return nil, p.accessError(ref)
+	}
+	return data, nil
+}
+
+// objectValue returns the referenced key of the object data, or all of the
+// data as json if no key is given.
+func objectValue(ref *objectRef, data map[string]string) (string, error) {
+	if ref.key == "" {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+
+	value, ok := data[ref.key]
+	if !ok {
+		return "", fmt.Errorf("%w: key %s in %s %s/%s", providers.ErrNotFound, ref.key, ref.kind, ref.namespace, ref.name)
+	}
+	return value, nil
+}
+
+func (p *ObjectProvider) accessError(ref *objectRef) error {
+	msg := fmt.Sprintf("access to %s %s/%s from namespace %q is not allowed", ref.kind, ref.namespace, ref.name, p.namespace)
+	return ierrors.New(ierrors.PermissionDenied, msg)
+}
+
+// parseObjectRef parses a key of the form [secret:|configmap:][<namespace>/]<name>[#<key>].
+// If no namespace is given, the namespace of the resource being replaced is used.
+func (p *ObjectProvider) parseObjectRef(key string) (*objectRef, error) {
+	key = strings.Trim(key, " \t")
+
+	res := keyPattern.FindStringSubmatch(key)
+	if res == nil {
+		return nil, ierrors.New(ierrors.InvalidArgument, "invalid object key: "+key)
+	}
+
+	ref := &objectRef{
+		kind:      res[1],
+		namespace: res[2],
+		name:      res[3],
+		key:       res[4],
+	}
+	if ref.kind == "" {
+		ref.kind = kindSecret
+	}
+	if ref.namespace == "" {
+		if p.namespace == "" {
+			return nil, ierrors.New(ierrors.InvalidArgument, "missing namespace in key: "+key)
+		}
+		ref.namespace = p.namespace
+	}
+	return ref, nil
+}
+
+// isNamespaceAllowed returns true if the allow-namespaces annotation of a source
+// object contains the given namespace or a wildcard.
+func isNamespaceAllowed(annotations map[string]string, namespace string) bool {
+	for _, ns := range strings.Split(annotations[AllowNamespacesAnnotation], ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "*" || (ns != "" && ns == namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapError adds the code for an error returned by the api server.
+func wrapError(err error) error {
+	switch {
+	case apierrors.IsNotFound(err):
+		return ierrors.Wrap(ierrors.NotFound, err)
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return ierrors.Wrap(ierrors.PermissionDenied, err)
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), apierrors.IsTooManyRequests(err),
+		apierrors.IsServiceUnavailable(err), apierrors.IsInternalError(err):
+		return ierrors.Wrap(ierrors.BackendError, err)
+	}
+	return err
+}
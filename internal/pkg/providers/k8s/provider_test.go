@@ -0,0 +1,180 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aar10n/replacer/internal/pkg/providers"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestProvider(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "K8s Provider Suite")
+}
+
+func newFakeClient() client.Client {
+	return fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "db"},
+			Data:       map[string][]byte{"password": []byte("hunter2"), "user": []byte("admin")},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "settings"},
+			Data:       map[string]string{"host": "db.internal"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "private"},
+			Data:       map[string][]byte{"token": []byte("team-b-token")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "shared",
+				Name:        "registry",
+				Annotations: map[string]string{AllowNamespacesAnnotation: "team-c, team-a"},
+			},
+			Data: map[string][]byte{"token": []byte("shared-token")},
+		},
+	).Build()
+}
+
+func newTestProvider(policy CrossNamespacePolicy, namespace string) *ObjectProvider {
+	p := NewObjectProvider(newFakeClient(), policy)
+	p.SetNamespace(namespace)
+	return p
+}
+
+// countingReader counts the objects read with Get.
+type countingReader struct {
+	client.Reader
+	gets int
+}
+
+func (r *countingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	r.gets++
+	return r.Reader.Get(ctx, key, obj)
+}
+
+var _ = Describe("K8s Provider", func() {
+	Describe("parseObjectRef", func() {
+		It("should default to a secret in the current namespace", func() {
+			provider := newTestProvider(CrossNamespaceDeny, "team-a")
+
+			ref, err := provider.parseObjectRef("db#password")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*ref).To(Equal(objectRef{kind: "secret", namespace: "team-a", name: "db", key: "password"}))
+		})
+		It("should parse a configmap in another namespace", func() {
+			provider := newTestProvider(CrossNamespaceDeny, "team-a")
+
+			ref, err := provider.parseObjectRef("configmap:shared/settings#host")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*ref).To(Equal(objectRef{kind: "configmap", namespace: "shared", name: "settings", key: "host"}))
+		})
+		It("should return an error for an invalid key", func() {
+			provider := newTestProvider(CrossNamespaceDeny, "team-a")
+
+			_, err := provider.parseObjectRef("pod:team-a/db")
+			Expect(err).To(HaveOccurred())
+			_, err = provider.parseObjectRef("a/b/c")
+			Expect(err).To(HaveOccurred())
+		})
+		It("should return an error if no namespace is known", func() {
+			provider := newTestProvider(CrossNamespaceDeny, "")
+
+			_, err := provider.parseObjectRef("db#password")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ValueFor", func() {
+		It("should return values from secrets and configmaps", func() {
+			provider := newTestProvider(CrossNamespaceDeny, "team-a")
+
+			value, err := provider.ValueFor("db#password")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("hunter2"))
+
+			value, err = provider.ValueFor("team-a/db#user")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("admin"))
+
+			value, err = provider.ValueFor("configmap:settings#host")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("db.internal"))
+		})
+		It("should return all data as json if no key is given", func() {
+			provider := newTestProvider(CrossNamespaceDeny, "team-a")
+
+			value, err := provider.ValueFor("db")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(MatchJSON(`{"password":"hunter2","user":"admin"}`))
+		})
+		It("should return an error for missing objects and keys", func() {
+			provider := newTestProvider(CrossNamespaceDeny, "team-a")
+
+			_, err := provider.ValueFor("missing#password")
+			Expect(err).To(MatchError(providers.ErrNotFound))
+			_, err = provider.ValueFor("db#missing")
+			Expect(err).To(MatchError(providers.ErrNotFound))
+		})
+		It("should deny cross-namespace reads by default", func() {
+			provider := newTestProvider(CrossNamespaceDeny, "team-a")
+
+			_, err := provider.ValueFor("team-b/private#token")
+			Expect(err).To(MatchError(ContainSubstring("not allowed")))
+			_, err = provider.ValueFor("shared/registry#token")
+			Expect(err).To(MatchError(ContainSubstring("not allowed")))
+		})
+		It("should allow cross-namespace reads of annotated objects", func() {
+			provider := newTestProvider(CrossNamespaceAnnotated, "team-a")
+
+			value, err := provider.ValueFor("shared/registry#token")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("shared-token"))
+
+			_, err = provider.ValueFor("team-b/private#token")
+			Expect(err).To(MatchError(ContainSubstring("not allowed")))
+			_, err = provider.ValueFor("team-b/missing#token")
+			Expect(err).To(MatchError(ContainSubstring("not allowed")))
+		})
+		It("should allow all cross-namespace reads with the allow policy", func() {
+			provider := newTestProvider(CrossNamespaceAllow, "team-a")
+
+			value, err := provider.ValueFor("team-b/private#token")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("team-b-token"))
+		})
+		It("should read each object once for many keys", func() {
+			reader := &countingReader{Reader: newFakeClient()}
+			provider := NewObjectProvider(reader, CrossNamespaceDeny)
+			provider.SetNamespace("team-a")
+
+			values, err := provider.ValuesFor(context.Background(), []string{
+				"db#user",
+				"team-a/db#password",
+				"db#missing",
+				"configmap:settings#host",
+				"missing#a",
+				"missing#b",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(Equal(map[string]string{
+				"db#user":                 "admin",
+				"team-a/db#password":      "hunter2",
+				"configmap:settings#host": "db.internal",
+			}))
+			Expect(reader.gets).To(Equal(3))
+
+			_, err = provider.ValuesFor(context.Background(), []string{"db#user", "team-b/private#token"})
+			Expect(err).To(MatchError(ContainSubstring("not allowed")))
+		})
+	})
+})
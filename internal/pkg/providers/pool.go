@@ -0,0 +1,249 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aar10n/replacer/pkg/config"
+)
+
+// Pool shares provider instances between replacers so that clients and caches
+// are reused across admission requests. Instances are keyed by the provider
+// name and config, as well as the namespace for NamespaceAware providers.
+// Instances which are not in use are closed once they have been idle for
+// longer than the idle timeout.
+type Pool struct {
+	idleTimeout time.Duration
+
+	lock       sync.Mutex
+	closed     bool
+	entries    map[string]*poolEntry
+	byProvider map[*Provider]*poolEntry
+	namespaced map[string]bool
+	// configs are the config types and defaults of the providers, used to
+	// parse configs without creating instances
+	configs map[string]poolConfig
+}
+
+// poolConfig is the struct type of a provider and the values of its config
+// as set by its factory.
+type poolConfig struct {
+	typ      reflect.Type
+	defaults map[string]string
+}
+
+type poolEntry struct {
+	key      string
+	provider *Provider
+	refs     int
+	lastUsed time.Time
+}
+
+// NewPool creates a new provider pool. If idleTimeout is zero, instances are
+// kept until the pool is closed.
+func NewPool(idleTimeout time.Duration) *Pool {
+	return &Pool{
+		idleTimeout: idleTimeout,
+		entries:     make(map[string]*poolEntry),
+		byProvider:  make(map[*Provider]*poolEntry),
+		namespaced:  make(map[string]bool),
+		configs:     make(map[string]poolConfig),
+	}
+}
+
+// Get returns an instance of the provider with the given name, config and
+// namespace, creating it if there is none. The config holds the settings of
+// the provider without any prefix. Instances are keyed by the effective
+// config, that is the config given to the factory defaults, so keys which are
+// not used by the provider do not create new instances.
+// The instance must be returned with Put once it is no longer used.
+func (p *Pool) Get(name string, cfg map[string]string, namespace string) (*Provider, error) {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil, errors.New("provider pool is closed")
+	}
+	pc, known := p.configs[name]
+	namespaced := p.namespaced[name]
+	p.lock.Unlock()
+
+	if known {
+		// the config can be parsed without creating an instance
+		values := reflect.New(pc.typ).Interface()
+		if err := config.LoadFromMap(pc.defaults, values); err != nil {
+			return nil, err
+		} else if err := config.LoadFromMap(cfg, values); err != nil {
+			return nil, err
+		}
+
+		key := poolKey(name, config.Values(values), namespace, namespaced)
+		if provider := p.acquire(key); provider != nil {
+			return provider, nil
+		}
+	}
+
+	// instantiate provider outside of the lock, since it may connect to
+	// the backend
+	provider, err := Use(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := config.Values(provider.ValueProvider)
+	err = config.LoadFromMap(cfg, provider.ValueProvider)
+	if err != nil {
+		provider.Close()
+		return nil, err
+	}
+
+	na, namespaced := provider.ValueProvider.(NamespaceAware)
+	if namespaced {
+		na.SetNamespace(namespace)
+	}
+	key := poolKey(name, config.Values(provider.ValueProvider), namespace, namespaced)
+
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		provider.Close()
+		return nil, errors.New("provider pool is closed")
+	}
+
+	p.namespaced[name] = namespaced
+	if t := reflect.TypeOf(provider.ValueProvider); t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+		p.configs[name] = poolConfig{typ: t.Elem(), defaults: defaults}
+	}
+
+	// another request may have created the same instance in the meantime
+	if e, ok := p.entries[key]; ok {
+		e.refs++
+		p.lock.Unlock()
+		provider.Close()
+		return e.provider, nil
+	}
+
+	e := &poolEntry{key: key, provider: provider, refs: 1}
+	p.entries[key] = e
+	p.byProvider[provider] = e
+	p.lock.Unlock()
+	return provider, nil
+}
+
+// acquire returns the instance with the given key, or nil if there is none.
+func (p *Pool) acquire(key string) *Provider {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if e, ok := p.entries[key]; ok && !p.closed {
+		e.refs++
+		return e.provider
+	}
+	return nil
+}
+
+// Put returns a provider obtained from Get to the pool.
+func (p *Pool) Put(provider *Provider) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	e, ok := p.byProvider[provider]
+	if !ok {
+		return
+	}
+
+	e.refs--
+	e.lastUsed = time.Now()
+	if p.closed && e.refs == 0 {
+		p.remove(e)
+	}
+}
+
+// Start periodically closes idle providers until ctx is done, at which point
+// all providers are closed. It implements manager.Runnable.
+func (p *Pool) Start(ctx context.Context) error {
+	defer p.Close()
+	if p.idleTimeout <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			p.evictIdle(now)
+		}
+	}
+}
+
+// NeedLeaderElection returns false since the pool is used by the webhooks
+// which run on every replica.
+func (p *Pool) NeedLeaderElection() bool {
+	return false
+}
+
+// Close closes all providers which are not in use. Providers which are still
+// in use are closed once they are returned.
+func (p *Pool) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.closed = true
+	for _, e := range p.entries {
+		if e.refs == 0 {
+			p.remove(e)
+		}
+	}
+}
+
+// Len returns the number of provider instances in the pool.
+func (p *Pool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.entries)
+}
+
+func (p *Pool) evictIdle(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, e := range p.entries {
+		if e.refs == 0 && now.Sub(e.lastUsed) >= p.idleTimeout {
+			p.remove(e)
+		}
+	}
+}
+
+func (p *Pool) remove(e *poolEntry) {
+	delete(p.entries, e.key)
+	delete(p.byProvider, e.provider)
+	e.provider.Close()
+}
+
+// poolKey returns the key of a provider instance in the pool, given the
+// values of its parsed config.
+func poolKey(name string, cfg map[string]string, namespace string, namespaced bool) string {
+	keys := make([]string, 0, len(cfg))
+	for k := range cfg {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(name)
+	for _, k := range keys {
+		sb.WriteString("\x00" + k + "=" + cfg[k])
+	}
+	if namespaced {
+		sb.WriteString("\x00\x00" + namespace)
+	}
+	return sb.String()
+}
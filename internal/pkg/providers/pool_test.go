@@ -0,0 +1,198 @@
+package providers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestProviders(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Providers")
+}
+
+type poolTestProvider struct {
+	Value     string `config:"value"`
+	namespace string
+	closed    bool
+}
+
+func (p *poolTestProvider) ValueFor(key string) (string, error) {
+	return p.Value + p.namespace, nil
+}
+
+func (p *poolTestProvider) Close() {
+	p.closed = true
+}
+
+type namespacedPoolTestProvider struct {
+	poolTestProvider
+}
+
+func (p *namespacedPoolTestProvider) SetNamespace(namespace string) {
+	p.namespace = namespace
+}
+
+func closed(p *Provider) bool {
+	switch vp := p.ValueProvider.(type) {
+	case *poolTestProvider:
+		return vp.closed
+	case *namespacedPoolTestProvider:
+		return vp.closed
+	}
+	return false
+}
+
+var _ = Describe("Pool", func() {
+	Register("pool-test", func() (ValueProvider, error) {
+		return &poolTestProvider{}, nil
+	})
+	Register("pool-test-namespaced", func() (ValueProvider, error) {
+		return &namespacedPoolTestProvider{}, nil
+	})
+
+	It("should reuse providers with the same config", func() {
+		pool := NewPool(0)
+
+		p1, err := pool.Get("pool-test", map[string]string{"value": "a"}, "ns1")
+		Expect(err).ToNot(HaveOccurred())
+		p2, err := pool.Get("pool-test", map[string]string{"value": "a"}, "ns2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p2).To(BeIdenticalTo(p1))
+
+		p3, err := pool.Get("pool-test", map[string]string{"value": "b"}, "ns1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p3).ToNot(BeIdenticalTo(p1))
+		Expect(p3.ValueFor("key")).To(Equal("b"))
+		Expect(pool.Len()).To(Equal(2))
+	})
+
+	It("should ignore keys which are not used by the provider", func() {
+		pool := NewPool(0)
+
+		p1, err := pool.Get("pool-test", map[string]string{"value": "a", "unknown": "x"}, "")
+		Expect(err).ToNot(HaveOccurred())
+		p2, err := pool.Get("pool-test", map[string]string{"value": "a", "unknown": "y"}, "")
+		Expect(err).ToNot(HaveOccurred())
+		p3, err := pool.Get("pool-test", map[string]string{"value": "a"}, "")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(p2).To(BeIdenticalTo(p1))
+		Expect(p3).To(BeIdenticalTo(p1))
+		Expect(pool.Len()).To(Equal(1))
+	})
+
+	It("should key providers by the config with the defaults of the factory", func() {
+		var created int
+		Register("pool-test-defaults", func() (ValueProvider, error) {
+			created++
+			return &poolTestProvider{Value: "default"}, nil
+		})
+		pool := NewPool(0)
+
+		p1, err := pool.Get("pool-test-defaults", nil, "")
+		Expect(err).ToNot(HaveOccurred())
+		p2, err := pool.Get("pool-test-defaults", nil, "")
+		Expect(err).ToNot(HaveOccurred())
+		p3, err := pool.Get("pool-test-defaults", map[string]string{"value": "default"}, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p2).To(BeIdenticalTo(p1))
+		Expect(p3).To(BeIdenticalTo(p1))
+		Expect(created).To(Equal(1))
+
+		p4, err := pool.Get("pool-test-defaults", map[string]string{"value": ""}, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p4).ToNot(BeIdenticalTo(p1))
+		Expect(p4.ValueFor("key")).To(Equal(""))
+		Expect(p1.ValueFor("key")).To(Equal("default"))
+	})
+
+	It("should not block other requests while creating a provider", func() {
+		release := make(chan struct{})
+		Register("pool-test-slow", func() (ValueProvider, error) {
+			<-release
+			return &poolTestProvider{}, nil
+		})
+		pool := NewPool(0)
+
+		done := make(chan *Provider, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				defer GinkgoRecover()
+				p, err := pool.Get("pool-test-slow", nil, "")
+				Expect(err).ToNot(HaveOccurred())
+				done <- p
+			}()
+		}
+
+		_, err := pool.Get("pool-test", nil, "")
+		Expect(err).ToNot(HaveOccurred())
+
+		close(release)
+		p1, p2 := <-done, <-done
+		Expect(p2).To(BeIdenticalTo(p1))
+		Expect(pool.Len()).To(Equal(2))
+	})
+
+	It("should key namespace aware providers by namespace", func() {
+		pool := NewPool(0)
+
+		p1, err := pool.Get("pool-test-namespaced", nil, "ns1")
+		Expect(err).ToNot(HaveOccurred())
+		p2, err := pool.Get("pool-test-namespaced", nil, "ns2")
+		Expect(err).ToNot(HaveOccurred())
+		p3, err := pool.Get("pool-test-namespaced", nil, "ns1")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(p2).ToNot(BeIdenticalTo(p1))
+		Expect(p3).To(BeIdenticalTo(p1))
+		Expect(p1.ValueFor("key")).To(Equal("ns1"))
+		Expect(p2.ValueFor("key")).To(Equal("ns2"))
+	})
+
+	It("should close idle providers", func() {
+		pool := NewPool(time.Minute)
+
+		p1, err := pool.Get("pool-test", nil, "")
+		Expect(err).ToNot(HaveOccurred())
+		p2, err := pool.Get("pool-test", map[string]string{"value": "b"}, "")
+		Expect(err).ToNot(HaveOccurred())
+
+		pool.Put(p1)
+		pool.evictIdle(time.Now().Add(2 * time.Minute))
+		Expect(closed(p1)).To(BeTrue())
+		Expect(closed(p2)).To(BeFalse())
+		Expect(pool.Len()).To(Equal(1))
+
+		pool.Put(p2)
+		pool.evictIdle(time.Now())
+		Expect(closed(p2)).To(BeFalse())
+	})
+
+	It("should close all providers when stopped", func() {
+		pool := NewPool(time.Minute)
+
+		p1, err := pool.Get("pool-test", nil, "")
+		Expect(err).ToNot(HaveOccurred())
+		p2, err := pool.Get("pool-test", map[string]string{"value": "b"}, "")
+		Expect(err).ToNot(HaveOccurred())
+		pool.Put(p1)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(pool.Start(ctx)).To(Succeed())
+		Expect(closed(p1)).To(BeTrue())
+		Expect(closed(p2)).To(BeFalse())
+
+		// providers in use are closed once they are returned
+		pool.Put(p2)
+		Expect(closed(p2)).To(BeTrue())
+		Expect(pool.Len()).To(Equal(0))
+
+		_, err = pool.Get("pool-test", nil, "")
+		Expect(err).To(HaveOccurred())
+	})
+})
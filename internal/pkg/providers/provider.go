@@ -1,11 +1,18 @@
 package providers
 
 import (
+	"context"
 	"errors"
+
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
 )
 
 var (
 	providers = make(map[string]Factory)
+
+	// ErrNotFound should be returned (or wrapped) by providers when the
+	// requested key does not exist.
+	ErrNotFound = ierrors.ErrNotFound
 )
 
 type Provider struct {
@@ -19,11 +26,36 @@ type ValueProvider interface {
 	ValueFor(key string) (string, error)
 }
 
+type ContextValueProvider interface {
+	// ValueForContext is like ValueFor but takes a context which is canceled
+	// when the request being replaced is aborted or runs out of time.
+	ValueForContext(ctx context.Context, key string) (string, error)
+}
+
+type BatchValueProvider interface {
+	// ValuesFor returns the values for the given keys. Keys which do not exist
+	// are left out of the result. It is used instead of ValueFor to resolve
+	// many keys at once for backends with batch APIs.
+	ValuesFor(ctx context.Context, keys []string) (map[string]string, error)
+}
+
 type Closer interface {
 	// Close should perform any cleanup required by the provider.
 	Close()
 }
 
+type NamespaceAware interface {
+	// SetNamespace is called with the namespace of the resource being replaced
+	// before any values are requested from the provider.
+	SetNamespace(namespace string)
+}
+
+// NotFound wraps err so that errors.Is(err, ErrNotFound) reports true while
+// keeping err in the chain.
+func NotFound(err error) error {
+	return ierrors.Wrap(ierrors.NotFound, err)
+}
+
 type Factory func() (ValueProvider, error)
 
 // Register associates a name with a provider factory. It should be called from
@@ -37,15 +69,21 @@ func Register(name string, factory Factory) {
 	providers[name] = factory
 }
 
+// Registered returns true if a provider with the given name is registered.
+func Registered(name string) bool {
+	_, ok := providers[name]
+	return ok
+}
+
 // Use returns a new instance of the provider with the given name. It returns an
 // error if no such provider is registered, or if the provider fails to initialize.
 func Use(name string) (*Provider, error) {
 	f, ok := providers[name]
 	if !ok {
 		if name == "" {
-			return nil, errors.New("no provider given")
+			return nil, ierrors.New(ierrors.InvalidProvider, "no provider given")
 		}
-		return nil, errors.New("provider not found: " + name)
+		return nil, ierrors.New(ierrors.InvalidProvider, "provider not found: "+name)
 	}
 
 	vp, err := f()
@@ -60,6 +98,62 @@ func Use(name string) (*Provider, error) {
 	return p, nil
 }
 
+// ValueForContext returns a value for the given key. Providers which do not
+// implement ContextValueProvider are called in a separate goroutine so that
+// the call returns once ctx is done, even if the provider has not.
+func (p *Provider) ValueForContext(ctx context.Context, key string) (string, error) {
+	if cp, ok := p.ValueProvider.(ContextValueProvider); ok {
+		return cp.ValueForContext(ctx, key)
+	} else if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	type result struct {
+		value string
+		err   error
+	}
+
+	resCh := make(chan result, 1)
+	go func() {
+		value, err := p.ValueProvider.ValueFor(key)
+		resCh <- result{value, err}
+	}()
+
+	select {
+	case res := <-resCh:
+		return res.value, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// SupportsBatch returns true if the provider implements BatchValueProvider.
+func (p *Provider) SupportsBatch() bool {
+	_, ok := p.ValueProvider.(BatchValueProvider)
+	return ok
+}
+
+// ValuesFor returns the values for the given keys. Keys which do not exist are
+// left out of the result. Providers which do not implement BatchValueProvider
+// are asked for one key at a time.
+func (p *Provider) ValuesFor(ctx context.Context, keys []string) (map[string]string, error) {
+	if bp, ok := p.ValueProvider.(BatchValueProvider); ok {
+		return bp.ValuesFor(ctx, keys)
+	}
+
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, err := p.ValueForContext(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
 // Close performs cleanup required by the provider.
 func (p *Provider) Close() {
 	if closer, ok := p.ValueProvider.(Closer); ok {
@@ -0,0 +1,51 @@
+package providers
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter limits the rate of calls to each provider across all replacers,
+// so that a single resource with many tags cannot use up the quota of a backend
+// for the whole cluster.
+type RateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	lock     sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewRateLimiter creates a rate limiter which allows qps calls per second to
+// each provider with bursts of up to burst calls. If qps is zero, calls are
+// not limited.
+func NewRateLimiter(qps float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		limit:    rate.Limit(qps),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// Wait blocks until a call to the named provider is allowed or ctx is done.
+// A nil rate limiter allows all calls.
+func (l *RateLimiter) Wait(ctx context.Context, name string) error {
+	if l == nil || l.limit <= 0 {
+		return ctx.Err()
+	}
+
+	l.lock.Lock()
+	limiter, ok := l.limiters[name]
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[name] = limiter
+	}
+	l.lock.Unlock()
+
+	return limiter.Wait(ctx)
+}
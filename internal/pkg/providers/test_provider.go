@@ -1,6 +1,6 @@
 package providers
 
-import "errors"
+import "fmt"
 
 type TestProvider struct {
 	replacements map[string]string
@@ -16,5 +16,5 @@ func (p *TestProvider) ValueFor(key string) (string, error) {
 	if value, ok := p.replacements[key]; ok {
 		return value, nil
 	}
-	return "", errors.New("key not found")
+	return "", fmt.Errorf("%w: %s", ErrNotFound, key)
 }
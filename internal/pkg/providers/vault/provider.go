@@ -0,0 +1,471 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/providers"
+	"github.com/aar10n/replacer/pkg/cache"
+	"github.com/aar10n/replacer/pkg/vault"
+)
+
+// HashiCorp Vault Provider
+
+const (
+	authMethodToken      = "token"
+	authMethodAppRole    = "approle"
+	authMethodKubernetes = "kubernetes"
+
+	serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	minRenewInterval        = time.Second
+
+	defaultMount     = "secret"
+	defaultKVVersion = 2
+)
+
+type KVProvider struct {
+	client *vault.Client
+	cache  *cache.Cache
+	lock   sync.Mutex
+	stopCh chan struct{}
+	closed bool
+
+	// settings taken from the environment of the webhook
+	defaultAddress   string
+	allowedAddresses []string
+	mounts           map[string]int // allowed mounts and their kv versions
+	token            string
+	secretID         string
+	jwtPath          string
+
+	Address    string `config:"address"`
+	Namespace  string `config:"namespace"`
+	Mount      string `config:"mount"`
+	AuthMethod string `config:"auth_method"`
+	AuthMount  string `config:"auth_mount"`
+	Role       string `config:"role"`
+	RoleID     string `config:"role_id"`
+	CABundle   string `config:"ca_bundle"`
+}
+
+type secretRef struct {
+	mount   string
+	version int
+	path    string
+	field   string
+}
+
+func KVProviderFactory() (providers.ValueProvider, error) {
+	mounts, err := parseMounts(os.Getenv("VAULT_ALLOWED_MOUNTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &KVProvider{
+		cache:            cache.New(),
+		defaultAddress:   os.Getenv("VAULT_ADDR"),
+		allowedAddresses: splitList(os.Getenv("VAULT_ALLOWED_ADDRESSES")),
+		mounts:           mounts,
+		token:            os.Getenv("VAULT_TOKEN"),
+		secretID:         os.Getenv("VAULT_SECRET_ID"),
+		jwtPath:          serviceAccountTokenPath,
+		Namespace:        os.Getenv("VAULT_NAMESPACE"),
+		RoleID:           os.Getenv("VAULT_ROLE_ID"),
+	}
+	return p, nil
+}
+
+func (p *KVProvider) ValueFor(key string) (string, error) {
+	return p.ValueForContext(context.Background(), key)
+}
+
+func (p *KVProvider) ValueForContext(ctx context.Context, key string) (string, error) {
+	ref, err := p.parseSecretRef(key)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := p.readSecret(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return fieldValue(data, ref.field)
+}
+
+// ValuesFor reads each secret referenced by keys once and returns the values
+// of all keys.
+func (p *KVProvider) ValuesFor(ctx context.Context, keys []string) (map[string]string, error) {
+	refs := make(map[string]*secretRef, len(keys))
+	for _, key := range keys {
+		ref, err := p.parseSecretRef(key)
+		if err != nil {
+			return nil, err
+		}
+		refs[key] = ref
+	}
+
+	secrets := make(map[string]map[string]interface{})
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		ref := refs[key]
+		path := p.secretPath(ref)
+
+		data, ok := secrets[path]
+		if !ok {
+			var err error
+			data, err = p.readSecret(ctx, ref)
+			if err != nil && !errors.Is(err, providers.ErrNotFound) {
+				return nil, err
+			}
+			secrets[path] = data
+		}
+		if data == nil {
+			continue
+		}
+
+		value, err := fieldValue(data, ref.field)
+		if errors.Is(err, providers.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (p *KVProvider) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.closed = true
+	if p.stopCh != nil {
+		close(p.stopCh)
+		p.stopCh = nil
+	}
+}
+
+func (p *KVProvider) readSecret(ctx context.Context, ref *secretRef) (map[string]interface{}, error) {
+	path := p.secretPath(ref)
+
+	if value := p.cache.Get(path); value != nil {
+		return value.(map[string]interface{}), nil
+	}
+
+	client, err := p.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := client.Read(ctx, path)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	if ref.version == 2 {
+		// kv v2 nests the secret data under data.data
+		inner, ok := data["data"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: secret %s has no data", providers.ErrNotFound, path)
+		}
+		data = inner
+	}
+
+	p.cache.Set(path, data)
+	return data, nil
+}
+
+// secretPath returns the api path of a secret.
+func (p *KVProvider) secretPath(ref *secretRef) string {
+	if ref.version == 2 {
+		return ref.mount + "/data/" + ref.path
+	}
+	return ref.mount + "/" + ref.path
+}
+
+// getClient returns the client, creating it and logging in on first use. The
+// login happens outside the lock, so that a slow login does not block reads
+// of cached secrets, and the first client to log in is kept.
+func (p *KVProvider) getClient(ctx context.Context) (*vault.Client, error) {
+	p.lock.Lock()
+	client := p.client
+	p.lock.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	address, err := p.getAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err = vault.NewClient(vault.Config{
+		Address:   address,
+		Namespace: p.Namespace,
+		CACert:    []byte(p.CABundle),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := p.login(ctx, client)
+	var vaultErr *vault.Error
+	if errors.As(err, &vaultErr) && vaultErr.StatusCode < http.StatusInternalServerError {
+		// rejected credentials
+		return nil, ierrors.Wrap(ierrors.PermissionDenied, err)
+	} else if err != nil {
+		return nil, wrapError(err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.client != nil {
+		return p.client, nil
+	} else if p.closed {
+		return nil, fmt.Errorf("vault provider is closed")
+	}
+
+	if auth.Renewable && auth.LeaseDuration > 0 {
+		p.stopCh = make(chan struct{})
+		go p.renewLoop(client, auth, p.stopCh)
+	}
+
+	p.client = client
+	return client, nil
+}
+
+// getAddress returns the vault address to use. An address given in the config
+// must either match VAULT_ADDR or be listed in VAULT_ALLOWED_ADDRESSES, since
+// the webhook credentials are sent to it.
+func (p *KVProvider) getAddress() (string, error) {
+	if p.Address == "" || p.Address == p.defaultAddress {
+		if p.defaultAddress == "" {
+			return "", fmt.Errorf("missing vault address in config or environment")
+		}
+		return p.defaultAddress, nil
+	}
+
+	for _, addr := range p.allowedAddresses {
+		if p.Address == addr {
+			return p.Address, nil
+		}
+	}
+	return "", fmt.Errorf("vault address not allowed: %s", p.Address)
+}
+
+func (p *KVProvider) login(ctx context.Context, client *vault.Client) (*vault.Auth, error) {
+	method := p.AuthMethod
+	if method == "" {
+		if p.Role != "" {
+			method = authMethodKubernetes
+		} else {
+			method = authMethodToken
+		}
+	}
+
+	mount := p.AuthMount
+	if mount == "" {
+		mount = method
+	}
+
+	switch method {
+	case authMethodToken:
+		if p.token == "" {
+			return nil, fmt.Errorf("missing VAULT_TOKEN for token auth")
+		}
+		client.SetToken(p.token)
+		return client.LookupSelf(ctx)
+	case authMethodAppRole:
+		if p.RoleID == "" || p.secretID == "" {
+			return nil, fmt.Errorf("missing role_id or VAULT_SECRET_ID for approle auth")
+		}
+		return client.Login(ctx, mount, map[string]interface{}{
+			"role_id":   p.RoleID,
+			"secret_id": p.secretID,
+		})
+	case authMethodKubernetes:
+		if p.Role == "" {
+			return nil, fmt.Errorf("missing role for kubernetes auth")
+		}
+		jwt, err := os.ReadFile(p.jwtPath)
+		if err != nil {
+			return nil, err
+		}
+		return client.Login(ctx, mount, map[string]interface{}{
+			"role": p.Role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		})
+	}
+	return nil, fmt.Errorf("unsupported auth method: %s", method)
+}
+
+// renewLoop periodically renews the token lease until stopCh is closed. If a
+// renewal fails, the provider logs in again.
+func (p *KVProvider) renewLoop(client *vault.Client, auth *vault.Auth, stopCh chan struct{}) {
+	for {
+		interval := time.Duration(auth.LeaseDuration) * time.Second * 2 / 3
+		if interval < minRenewInterval {
+			interval = minRenewInterval
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(interval):
+		}
+
+		ctx := context.Background()
+		newAuth, err := client.RenewSelf(ctx)
+		if err != nil {
+			newAuth, err = p.login(ctx, client)
+			if err != nil {
+				// try again after the next interval
+				continue
+			}
+		}
+		auth = newAuth
+	}
+}
+
+// parseSecretRef parses a key of the form [<mount>/]<path>[#<field>]. If no
+// mount is configured, the first path segment is used as the mount. The mount
+// must be listed in VAULT_ALLOWED_MOUNTS, which also sets its kv version. For
+// kv v2 engines the data/ segment after the mount is optional.
+func (p *KVProvider) parseSecretRef(key string) (*secretRef, error) {
+	key = strings.Trim(key, " \t")
+
+	ref := &secretRef{}
+	if i := strings.Index(key, "#"); i >= 0 {
+		ref.field = key[i+1:]
+		key = key[:i]
+		if ref.field == "" {
+			return nil, ierrors.New(ierrors.InvalidArgument, "empty field in key: "+key)
+		}
+	}
+
+	key = strings.Trim(key, "/")
+	if mount := strings.Trim(p.Mount, "/"); mount != "" {
+		ref.mount = mount
+		ref.path = strings.TrimPrefix(key, mount+"/")
+	} else if i := strings.Index(key, "/"); i > 0 {
+		ref.mount = key[:i]
+		ref.path = key[i+1:]
+	}
+
+	if ref.mount == "" || ref.path == "" || !validSegments(ref.mount) || !validSegments(ref.path) {
+		return nil, ierrors.New(ierrors.InvalidArgument, "invalid secret path: "+key)
+	}
+
+	version, ok := p.mounts[ref.mount]
+	if !ok {
+		return nil, ierrors.New(ierrors.PermissionDenied, "vault mount not allowed: "+ref.mount)
+	}
+	ref.version = version
+	if version == 2 {
+		ref.path = strings.TrimPrefix(ref.path, "data/")
+	}
+	return ref, nil
+}
+
+// validSegments returns true if a path has no empty, . or .. segments.
+func validSegments(path string) bool {
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// parseMounts parses the allowed mounts, given as a comma-separated list of
+// <mount>[:<kv version>]. The version defaults to 2, and without any mounts
+// only the secret mount is allowed.
+func parseMounts(s string) (map[string]int, error) {
+	mounts := make(map[string]int)
+	for _, item := range splitList(s) {
+		mount, version := item, defaultKVVersion
+		if i := strings.LastIndex(item, ":"); i >= 0 {
+			v, err := strconv.Atoi(item[i+1:])
+			if err != nil || (v != 1 && v != 2) {
+				return nil, fmt.Errorf("invalid kv version in VAULT_ALLOWED_MOUNTS: %s", item)
+			}
+			mount, version = item[:i], v
+		}
+
+		mount = strings.Trim(mount, "/")
+		if mount == "" || !validSegments(mount) {
+			return nil, fmt.Errorf("invalid mount in VAULT_ALLOWED_MOUNTS: %s", item)
+		}
+		mounts[mount] = version
+	}
+
+	if len(mounts) == 0 {
+		mounts[defaultMount] = defaultKVVersion
+	}
+	return mounts, nil
+}
+
+// fieldValue returns a field of the secret data, or all of the data as json
+// if no field is given.
+func fieldValue(data map[string]interface{}, field string) (string, error) {
+	if field == "" {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+
+	v, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("%w: field %s in secret", providers.ErrNotFound, field)
+	} else if s, ok := v.(string); ok {
+		return s, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// wrapError adds the code for an error returned by vault. Requests that fail
+// to reach the server are backend errors.
+func wrapError(err error) error {
+	var vaultErr *vault.Error
+	var urlErr *url.Error
+	if errors.As(err, &vaultErr) {
+		return ierrors.Wrap(ierrors.FromHTTPStatus(vaultErr.StatusCode), err)
+	} else if errors.As(err, &urlErr) && !urlErr.Timeout() {
+		return ierrors.Wrap(ierrors.BackendError, err)
+	}
+	return err
+}
+
+//
+
+func init() {
+	// register the provider
+	providers.Register("vault", KVProviderFactory)
+}
@@ -0,0 +1,345 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/providers"
+	"github.com/aar10n/replacer/pkg/cache"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestProvider(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Vault Provider Suite")
+}
+
+type fakeVault struct {
+	*httptest.Server
+	reads    int32
+	renewals int32
+	logins   int32
+}
+
+// newFakeVault returns a server that implements the kv v1/v2 read, login and
+// token renewal endpoints.
+func newFakeVault(leaseDuration int) *fakeVault {
+	v := &fakeVault{}
+	v.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON := func(v interface{}) { _ = json.NewEncoder(w).Encode(v) }
+		auth := map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token":   "s.issued",
+				"lease_duration": leaseDuration,
+				"renewable":      true,
+			},
+		}
+
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login", "/v1/auth/approle/login":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["jwt"] != "sa-jwt" && body["secret_id"] != "secret-id" {
+				w.WriteHeader(http.StatusForbidden)
+				writeJSON(map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			atomic.AddInt32(&v.logins, 1)
+			writeJSON(auth)
+			return
+		case "/v1/auth/token/renew-self":
+			atomic.AddInt32(&v.renewals, 1)
+			writeJSON(auth)
+			return
+		case "/v1/auth/token/lookup-self":
+			writeJSON(map[string]interface{}{"data": map[string]interface{}{"ttl": 0, "renewable": false}})
+			return
+		}
+
+		token := r.Header.Get("X-Vault-Token")
+		if token != "s.issued" && token != "s.root" {
+			w.WriteHeader(http.StatusForbidden)
+			writeJSON(map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+
+		atomic.AddInt32(&v.reads, 1)
+		switch r.URL.Path {
+		case "/v1/secret/data/app":
+			writeJSON(map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     map[string]interface{}{"username": "admin", "password": "hunter2"},
+					"metadata": map[string]interface{}{"version": 3},
+				},
+			})
+		case "/v1/kv/app":
+			writeJSON(map[string]interface{}{
+				"data": map[string]interface{}{"password": "swordfish", "port": 5432},
+			})
+		case "/v1/ns-secret/data/app":
+			if r.Header.Get("X-Vault-Namespace") != "team-a" {
+				w.WriteHeader(http.StatusNotFound)
+				writeJSON(map[string]interface{}{"errors": []string{}})
+				return
+			}
+			writeJSON(map[string]interface{}{
+				"data": map[string]interface{}{"data": map[string]interface{}{"password": "team-a-pass"}},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			writeJSON(map[string]interface{}{"errors": []string{}})
+		}
+	}))
+	return v
+}
+
+func newTestProvider(server *fakeVault) *KVProvider {
+	return &KVProvider{
+		cache:          cache.New(),
+		defaultAddress: server.URL,
+		mounts:         testMounts,
+	}
+}
+
+// testMounts are the mounts of the fake vault server.
+var testMounts = map[string]int{"secret": 2, "ns-secret": 2, "kv": 1}
+
+var _ = Describe("Vault Provider", func() {
+	Describe("parseSecretRef", func() {
+		It("should parse a kv v2 path with the data segment", func() {
+			provider := &KVProvider{mounts: testMounts}
+
+			ref, err := provider.parseSecretRef("secret/data/app#password")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*ref).To(Equal(secretRef{mount: "secret", version: 2, path: "app", field: "password"}))
+		})
+		It("should parse a kv v2 path without the data segment", func() {
+			provider := &KVProvider{mounts: testMounts}
+
+			ref, err := provider.parseSecretRef("secret/team/app")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*ref).To(Equal(secretRef{mount: "secret", version: 2, path: "team/app"}))
+		})
+		It("should use the configured mount", func() {
+			provider := &KVProvider{mounts: testMounts, Mount: "kv"}
+
+			ref, err := provider.parseSecretRef("app#password")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*ref).To(Equal(secretRef{mount: "kv", version: 1, path: "app", field: "password"}))
+
+			ref, err = provider.parseSecretRef("kv/app")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*ref).To(Equal(secretRef{mount: "kv", version: 1, path: "app"}))
+		})
+		It("should return an error for an invalid path", func() {
+			provider := &KVProvider{mounts: testMounts}
+
+			for _, key := range []string{"app", "secret/app#", "secret/../sys/policy", "../sys/policy", "secret/./app", "secret//app"} {
+				_, err := provider.parseSecretRef(key)
+				Expect(err).To(MatchError(ierrors.ErrInvalidArgument), key)
+			}
+		})
+		It("should refuse mounts that are not allowed", func() {
+			provider := &KVProvider{mounts: testMounts}
+
+			_, err := provider.parseSecretRef("auth/token/lookup-self")
+			Expect(err).To(MatchError(ierrors.ErrPermissionDenied))
+
+			provider.Mount = "sys"
+			_, err = provider.parseSecretRef("policy/root")
+			Expect(err).To(MatchError(ierrors.ErrPermissionDenied))
+		})
+	})
+
+	DescribeTable("parseMounts",
+		func(s string, expected map[string]int) {
+			mounts, err := parseMounts(s)
+			if expected == nil {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(mounts).To(Equal(expected))
+			}
+		},
+		Entry(nil, "", map[string]int{"secret": 2}),
+		Entry(nil, "kv, /team-a/kv:1 ", map[string]int{"kv": 2, "team-a/kv": 1}),
+		Entry(nil, "kv:3", nil),
+		Entry(nil, "../sys", nil),
+	)
+
+	Describe("ValueFor", func() {
+		var server *fakeVault
+
+		BeforeEach(func() {
+			server = newFakeVault(3600)
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should read a field from a kv v2 secret with token auth", func() {
+			provider := newTestProvider(server)
+			provider.token = "s.root"
+
+			value, err := provider.ValueFor("secret/data/app#password")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("hunter2"))
+		})
+		It("should read a whole kv v2 secret as json", func() {
+			provider := newTestProvider(server)
+			provider.token = "s.root"
+
+			value, err := provider.ValueFor("secret/app")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(MatchJSON(`{"username":"admin","password":"hunter2"}`))
+		})
+		It("should read a field from a kv v1 secret", func() {
+			provider := newTestProvider(server)
+			provider.token = "s.root"
+
+			value, err := provider.ValueFor("kv/app#password")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("swordfish"))
+
+			value, err = provider.ValueFor("kv/app#port")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("5432"))
+		})
+		It("should send the configured namespace", func() {
+			provider := newTestProvider(server)
+			provider.token = "s.root"
+			provider.Namespace = "team-a"
+
+			value, err := provider.ValueFor("ns-secret/app#password")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("team-a-pass"))
+		})
+		It("should log in with kubernetes auth", func() {
+			jwtPath := filepath.Join(GinkgoT().TempDir(), "token")
+			Expect(os.WriteFile(jwtPath, []byte("sa-jwt\n"), 0600)).To(Succeed())
+
+			provider := newTestProvider(server)
+			provider.jwtPath = jwtPath
+			provider.Role = "replacer"
+			defer provider.Close()
+
+			value, err := provider.ValueFor("secret/app#username")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("admin"))
+			Expect(atomic.LoadInt32(&server.logins)).To(Equal(int32(1)))
+		})
+		It("should log in with approle auth", func() {
+			provider := newTestProvider(server)
+			provider.AuthMethod = "approle"
+			provider.RoleID = "role-id"
+			provider.secretID = "secret-id"
+			defer provider.Close()
+
+			value, err := provider.ValueFor("secret/app#username")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("admin"))
+		})
+		It("should return an error for a missing secret or field", func() {
+			provider := newTestProvider(server)
+			provider.token = "s.root"
+
+			_, err := provider.ValueFor("secret/missing#password")
+			Expect(err).To(MatchError(providers.ErrNotFound))
+			_, err = provider.ValueFor("secret/app#missing")
+			Expect(err).To(MatchError(providers.ErrNotFound))
+		})
+		It("should return a permission error for rejected credentials", func() {
+			provider := newTestProvider(server)
+			provider.token = "s.invalid"
+
+			_, err := provider.ValueFor("secret/app#password")
+			Expect(err).To(MatchError(ierrors.ErrPermissionDenied))
+
+			provider = newTestProvider(server)
+			provider.AuthMethod = "approle"
+			provider.RoleID = "role-id"
+			provider.secretID = "wrong"
+
+			_, err = provider.ValueFor("secret/app#password")
+			Expect(err).To(MatchError(ierrors.ErrPermissionDenied))
+		})
+		It("should cache secrets", func() {
+			provider := newTestProvider(server)
+			provider.token = "s.root"
+
+			_, err := provider.ValueFor("secret/app#username")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provider.ValueFor("secret/app#password")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(atomic.LoadInt32(&server.reads)).To(Equal(int32(1)))
+		})
+		It("should read each secret once for many keys", func() {
+			provider := newTestProvider(server)
+			provider.token = "s.root"
+
+			values, err := provider.ValuesFor(context.Background(), []string{
+				"secret/app#username",
+				"secret/data/app#password",
+				"secret/app#missing",
+				"secret/missing#password",
+				"secret/missing#username",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(Equal(map[string]string{
+				"secret/app#username":      "admin",
+				"secret/data/app#password": "hunter2",
+			}))
+			Expect(atomic.LoadInt32(&server.reads)).To(Equal(int32(2)))
+		})
+		It("should refuse addresses that are not allowed", func() {
+			provider := newTestProvider(server)
+			provider.token = "s.root"
+			provider.Address = "https://attacker.example.com"
+
+			_, err := provider.ValueFor("secret/app#password")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("not allowed"))
+
+			provider.allowedAddresses = []string{server.URL}
+			provider.Address = server.URL
+			_, err = provider.ValueFor("secret/app#password")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("renewal", func() {
+		It("should renew the token lease in the background", func() {
+			server := newFakeVault(1)
+			defer server.Close()
+
+			provider := newTestProvider(server)
+			provider.AuthMethod = "approle"
+			provider.RoleID = "role-id"
+			provider.secretID = "secret-id"
+
+			_, err := provider.ValueFor("secret/app#password")
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(func() int32 {
+				return atomic.LoadInt32(&server.renewals)
+			}, 3*time.Second).Should(BeNumerically(">=", 1))
+
+			provider.Close()
+			time.Sleep(100 * time.Millisecond)
+			renewals := atomic.LoadInt32(&server.renewals)
+			Consistently(func() int32 {
+				return atomic.LoadInt32(&server.renewals)
+			}, 1500*time.Millisecond).Should(Equal(renewals))
+		})
+	})
+})
@@ -0,0 +1,149 @@
+package replacer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+)
+
+// Error is the error of a single tag that could not be replaced.
+type Error struct {
+	// Entry is the name of the data entry containing the tag.
+	Entry string
+	// Pos is the position of the tag in the entry.
+	Pos Position
+	// Provider is the name of the provider of the tag.
+	Provider string
+	// Key is the key of the tag. It is empty if the key was not evaluated.
+	Key string
+	// Code classifies the error.
+	Code ierrors.Code
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	msg := e.Err.Error()
+	if perr, ok := e.Err.(*ParseError); ok {
+		msg = perr.Msg
+	}
+
+	msg = fmt.Sprintf("%s: %s", e.Pos, msg)
+	if e.Entry != "" {
+		msg = e.Entry + ": " + msg
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is the sentinel error of the code of e.
+func (e *Error) Is(target error) bool {
+	return (&ierrors.Error{Code: e.Code}).Is(target)
+}
+
+// detail returns the error with its provider, key and class.
+func (e *Error) detail() string {
+	var fields []string
+	if e.Provider != "" {
+		fields = append(fields, "provider: "+e.Provider)
+	}
+	if e.Key != "" {
+		fields = append(fields, fmt.Sprintf("key: %q", e.Key))
+	}
+	fields = append(fields, "error: "+string(e.Code))
+	return fmt.Sprintf("%s (%s)", e.Error(), strings.Join(fields, ", "))
+}
+
+// Errors holds the errors of all tags that could not be replaced, ordered by
+// entry and position.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	var sb strings.Builder
+	if len(errs) == 1 {
+		sb.WriteString("1 replacement failed:")
+	} else {
+		fmt.Fprintf(&sb, "%d replacements failed:", len(errs))
+	}
+	for _, e := range errs {
+		sb.WriteString("\n- " + e.detail())
+	}
+	return sb.String()
+}
+
+// Is returns true if any of the errors matches target.
+func (errs Errors) Is(target error) bool {
+	for _, e := range errs {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error that matches target.
+func (errs Errors) As(target interface{}) bool {
+	for _, e := range errs {
+		if errors.As(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// err returns the errors as an error, or nil if there are none.
+func (errs Errors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// sort orders the errors by entry and position.
+func (errs Errors) sort() {
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Entry != errs[j].Entry {
+			return errs[i].Entry < errs[j].Entry
+		}
+		return errs[i].Pos.Offset < errs[j].Pos.Offset
+	})
+}
+
+// add appends err to the errors, flattening nested Errors.
+func (errs Errors) add(err error) Errors {
+	var multi Errors
+	var single *Error
+	if errors.As(err, &multi) {
+		return append(errs, multi...)
+	} else if errors.As(err, &single) {
+		return append(errs, single)
+	}
+	return append(errs, &Error{Code: ierrors.InternalError, Err: err})
+}
+
+// newError returns the error of a replacement. The code is used unless err
+// has a code of its own.
+func (rkey *replacement) newError(key string, code ierrors.Code, err error) *Error {
+	return &Error{
+		Entry:    rkey.entry,
+		Pos:      rkey.tag.Pos,
+		Provider: rkey.provider.Name,
+		Key:      key,
+		Code:     errorCode(err, code),
+		Err:      err,
+	}
+}
+
+// errorCode returns the code of err, or code if it has none.
+func errorCode(err error, code ierrors.Code) ierrors.Code {
+	if c := ierrors.CodeOf(err); c != "" {
+		return c
+	}
+	return code
+}
@@ -0,0 +1,165 @@
+package replacer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// escapeFormat is the format of the text surrounding a replacement tag.
+type escapeFormat string
+
+const (
+	escapeNone  escapeFormat = ""
+	escapeAuto  escapeFormat = "auto"
+	escapeJSON  escapeFormat = "json"
+	escapeYAML  escapeFormat = "yaml"
+	escapeShell escapeFormat = "shell"
+)
+
+var (
+	yamlBlockScalarPattern = regexp.MustCompile(`[|>][-+0-9]*\s*(?:#.*)?$`)
+)
+
+// parseEscapeFormat parses the value of the escape_replacements option.
+func parseEscapeFormat(s string) (escapeFormat, error) {
+	switch strings.ToLower(s) {
+	case "", "false", "0":
+		return escapeNone, nil
+	case "true", "1", "auto":
+		return escapeAuto, nil
+	case "json":
+		return escapeJSON, nil
+	case "yaml", "yml":
+		return escapeYAML, nil
+	case "shell", "sh":
+		return escapeShell, nil
+	}
+	return escapeNone, fmt.Errorf("invalid escape_replacements value: %s", s)
+}
+
+// formatForName infers the format of a data entry from the extension of its name.
+func formatForName(name string) escapeFormat {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		return escapeJSON
+	case ".yaml", ".yml":
+		return escapeYAML
+	case ".sh", ".bash", ".env":
+		return escapeShell
+	}
+	return escapeNone
+}
+
+// escapeValue escapes a replacement value for the given format. The text
+// preceding the tag is used to determine whether the tag is inside of a
+// quoted string, in which case only the contents are escaped. Otherwise the
+// value is converted to a complete quoted string. Binary values (which are not
+// valid utf-8) cannot be escaped.
+func escapeValue(format escapeFormat, value string, before string) (string, error) {
+	if format != escapeNone && !utf8.ValidString(value) {
+		return "", fmt.Errorf("cannot escape a binary value for %s", format)
+	}
+
+	line := before[strings.LastIndexByte(before, '\n')+1:]
+
+	switch format {
+	case escapeJSON:
+		if quoteContext(line, false) == '"' {
+			return jsonEscape(value), nil
+		}
+		return `"` + jsonEscape(value) + `"`, nil
+	case escapeYAML:
+		switch quoteContext(line, true) {
+		case '"':
+			return jsonEscape(value), nil
+		case '\'':
+			if strings.Contains(value, "\n") {
+				return "", fmt.Errorf("cannot escape a multi-line value inside of a single-quoted yaml string")
+			}
+			return strings.ReplaceAll(value, "'", "''"), nil
+		}
+
+		if indent, ok := yamlBlockScalarIndent(before); ok {
+			// inside of a block scalar the value is taken literally,
+			// but each line must be indented
+			return strings.ReplaceAll(value, "\n", "\n"+indent), nil
+		}
+		return `"` + jsonEscape(value) + `"`, nil
+	case escapeShell:
+		switch quoteContext(line, false) {
+		case '\'':
+			return strings.ReplaceAll(value, "'", `'\''`), nil
+		case '"':
+			r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+			return r.Replace(value), nil
+		}
+		return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'", nil
+	}
+	return value, nil
+}
+
+// quoteContext returns the quote character of the string that is open at the
+// end of line, or 0 if no string is open. Double quoted strings support
+// backslash escapes. If yamlComments is set, an unquoted # ends the scan.
+func quoteContext(line string, yamlComments bool) byte {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// in yaml a quote only starts a string at the start of a scalar
+			if yamlComments && c == '\'' && i > 0 && line[i-1] != ' ' && line[i-1] != '[' && line[i-1] != '{' && line[i-1] != ',' {
+				continue
+			}
+			quote = c
+		case yamlComments && c == '#' && (i == 0 || line[i-1] == ' '):
+			return 0
+		}
+	}
+	return quote
+}
+
+// yamlBlockScalarIndent returns the indentation of the current line if it is
+// part of a yaml block scalar (introduced by a line ending with | or >).
+func yamlBlockScalarIndent(before string) (string, bool) {
+	lines := strings.Split(before, "\n")
+	current := lines[len(lines)-1]
+	indent := current[:len(current)-len(strings.TrimLeft(current, " "))]
+	if len(lines) == 1 || strings.TrimSpace(current) != "" && indent == "" {
+		return "", false
+	}
+
+	for i := len(lines) - 2; i >= 0; i-- {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		lineIndent := len(line) - len(strings.TrimLeft(line, " "))
+		if lineIndent < len(indent) {
+			return indent, yamlBlockScalarPattern.MatchString(line)
+		}
+	}
+	return "", false
+}
+
+func jsonEscape(value string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(value)
+
+	s := strings.TrimSuffix(buf.String(), "\n")
+	return s[1 : len(s)-1]
+}
@@ -0,0 +1,493 @@
+package replacer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	tagPrefix    = "<replace"
+	escapePrefix = "<" + tagPrefix
+	maxTagDepth  = 8
+)
+
+// Template is a parsed string containing replacement tags.
+type Template struct {
+	Nodes []Node
+}
+
+// Node is a node in a template. It is either a *TextNode or a *TagNode.
+type Node interface {
+	node()
+}
+
+// TextNode is literal text.
+type TextNode struct {
+	Text string
+}
+
+// TagNode is a replacement tag, for example:
+//
+//	<replace(provider)?:key#.selector ?? "default" | filter arg>
+type TagNode struct {
+	// Pos is the position of the start of the tag.
+	Pos Position
+	// Raw is the source text of the tag.
+	Raw string
+	// Provider is the explicit provider name (may be empty).
+	Provider string
+	// Optional is set if the tag is marked with a ?.
+	Optional bool
+	// Key holds the parts of the key, which are text and nested tags.
+	Key []Node
+	// Selector is the selector following the key (may be empty).
+	Selector string
+	// Default is the default value, a *TextNode or a nested *TagNode (may be nil).
+	Default Node
+	// Filters are the filters applied to the value.
+	Filters []*FilterNode
+}
+
+// FilterNode is a filter in the pipeline of a tag.
+type FilterNode struct {
+	Pos  Position
+	Name string
+	Args []string
+}
+
+func (*TextNode) node() {}
+func (*TagNode) node()  {}
+
+// Position is a location in the source of a template.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// ParseError is returned for malformed templates.
+type ParseError struct {
+	Pos Position
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// Parse parses a string containing replacement tags. A tag starts with <replace
+// followed by one of '(', '?' or ':' and ends with '>'. Keys and arguments may be
+// quoted to include special characters, and a literal tag can be written as
+// <<replace followed by one of '(', '?' or ':'.
+func Parse(s string) (*Template, error) {
+	p := &parser{src: s}
+	for i, c := range s {
+		if c == '\n' {
+			p.lines = append(p.lines, i+1)
+		}
+	}
+
+	var nodes []Node
+	var text strings.Builder
+	for p.pos < len(p.src) {
+		switch {
+		case p.atEscape():
+			text.WriteString(tagPrefix)
+			p.pos += len(escapePrefix)
+		case p.atTag():
+			if text.Len() > 0 {
+				nodes = append(nodes, &TextNode{Text: text.String()})
+				text.Reset()
+			}
+
+			tag, err := p.parseTag(0)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, tag)
+		default:
+			text.WriteByte(p.src[p.pos])
+			p.pos++
+		}
+	}
+
+	if text.Len() > 0 {
+		nodes = append(nodes, &TextNode{Text: text.String()})
+	}
+	return &Template{Nodes: nodes}, nil
+}
+
+// HasTags returns true if the template contains any tags.
+func (t *Template) HasTags() bool {
+	for _, n := range t.Nodes {
+		if _, ok := n.(*TagNode); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Tags returns all top-level tags in the template.
+func (t *Template) Tags() []*TagNode {
+	var tags []*TagNode
+	for _, n := range t.Nodes {
+		if tag, ok := n.(*TagNode); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// StaticKey returns the key of the tag if it contains no nested tags.
+func (t *TagNode) StaticKey() (string, bool) {
+	var sb strings.Builder
+	for _, n := range t.Key {
+		text, ok := n.(*TextNode)
+		if !ok {
+			return "", false
+		}
+		sb.WriteString(text.Text)
+	}
+	return sb.String(), true
+}
+
+//
+
+type parser struct {
+	src      string
+	pos      int
+	tagStart int
+	lines    []int // offsets of the start of each line after the first
+}
+
+func (p *parser) position(offset int) Position {
+	line := sort.SearchInts(p.lines, offset+1)
+	start := 0
+	if line > 0 {
+		start = p.lines[line-1]
+	}
+	return Position{Offset: offset, Line: line + 1, Column: offset - start + 1}
+}
+
+func (p *parser) errorf(offset int, format string, args ...interface{}) error {
+	return &ParseError{Pos: p.position(offset), Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) atTag() bool {
+	return p.isTagAt(p.pos)
+}
+
+// atEscape returns true at a '<' escaping a tag. A <<replace which is not
+// followed by a tag is kept as-is.
+func (p *parser) atEscape() bool {
+	return strings.HasPrefix(p.src[p.pos:], escapePrefix) && p.isTagAt(p.pos+1)
+}
+
+func (p *parser) isTagAt(pos int) bool {
+	if !strings.HasPrefix(p.src[pos:], tagPrefix) || pos+len(tagPrefix) >= len(p.src) {
+		return false
+	}
+	c := p.src[pos+len(tagPrefix)]
+	return c == '(' || c == '?' || c == ':'
+}
+
+func (p *parser) atDefault() bool {
+	return strings.HasPrefix(p.src[p.pos:], "??")
+}
+
+func (p *parser) skipSpaces() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.pos++
+	}
+}
+
+// parseTag parses a tag starting at the current position.
+func (p *parser) parseTag(depth int) (*TagNode, error) {
+	start := p.pos
+	if depth >= maxTagDepth {
+		return nil, p.errorf(start, "tags are nested too deeply")
+	}
+
+	outer := p.tagStart
+	p.tagStart = start
+	defer func() { p.tagStart = outer }()
+
+	tag := &TagNode{Pos: p.position(start)}
+	p.pos += len(tagPrefix)
+
+	if p.peek() == '(' {
+		p.pos++
+		nameStart := p.pos
+		for isNameChar(p.peek()) {
+			p.pos++
+		}
+		tag.Provider = p.src[nameStart:p.pos]
+		if tag.Provider == "" {
+			return nil, p.errorf(nameStart, "missing provider name")
+		} else if p.peek() != ')' {
+			return nil, p.unexpected("in provider name")
+		}
+		p.pos++
+	}
+
+	if p.peek() == '?' {
+		tag.Optional = true
+		p.pos++
+	}
+
+	if p.peek() != ':' {
+		return nil, p.unexpected("expected ':'")
+	}
+	p.pos++
+
+	if err := p.parseKey(tag, depth); err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.atDefault() {
+		p.pos += 2
+		p.skipSpaces()
+
+		def, err := p.parseValue(depth)
+		if err != nil {
+			return nil, err
+		}
+		tag.Default = def
+		p.skipSpaces()
+	}
+
+	for p.peek() == '|' {
+		p.pos++
+		filter, err := p.parseFilter()
+		if err != nil {
+			return nil, err
+		}
+		tag.Filters = append(tag.Filters, filter)
+	}
+
+	if p.peek() != '>' {
+		if p.atDefault() {
+			return nil, p.errorf(p.pos, "default value must come before filters")
+		}
+		return nil, p.unexpected("expected '>'")
+	}
+	p.pos++
+
+	tag.Raw = p.src[start:p.pos]
+	return tag, nil
+}
+
+// parseKey parses the key and selector of a tag. The key ends at the first
+// unquoted '|', '>' or "??", and the selector starts at the first unquoted
+// "#." or "#[" in the key.
+func (p *parser) parseKey(tag *TagNode, depth int) error {
+	start := p.pos
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			tag.Key = append(tag.Key, &TextNode{Text: text.String()})
+			text.Reset()
+		}
+	}
+
+loop:
+	for p.pos < len(p.src) {
+		c := p.peek()
+		switch {
+		case c == '\n' || c == '>' || c == '|' || p.atDefault():
+			break loop
+		case c == '"' || c == '\'':
+			s, err := p.parseQuoted()
+			if err != nil {
+				return err
+			}
+			text.WriteString(s)
+		case c == '#' && p.pos+1 < len(p.src) && (p.src[p.pos+1] == '.' || p.src[p.pos+1] == '['):
+			sel, err := p.parseSelector()
+			if err != nil {
+				return err
+			}
+			tag.Selector = sel
+			break loop
+		case p.atTag():
+			flush()
+			nested, err := p.parseTag(depth + 1)
+			if err != nil {
+				return err
+			}
+			tag.Key = append(tag.Key, nested)
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+	flush()
+
+	// trim the whitespace surrounding the key
+	if n := len(tag.Key); n > 0 {
+		if first, ok := tag.Key[0].(*TextNode); ok {
+			first.Text = strings.TrimLeft(first.Text, " \t")
+		}
+		if last, ok := tag.Key[n-1].(*TextNode); ok {
+			last.Text = strings.TrimRight(last.Text, " \t")
+		}
+
+		var key []Node
+		for _, n := range tag.Key {
+			if text, ok := n.(*TextNode); !ok || text.Text != "" {
+				key = append(key, n)
+			}
+		}
+		tag.Key = key
+	}
+
+	if len(tag.Key) == 0 {
+		return p.errorf(start, "missing key")
+	}
+	return nil
+}
+
+// parseSelector parses a selector starting with '#'. Brackets in the selector
+// may contain quoted strings with special characters.
+func (p *parser) parseSelector() (string, error) {
+	p.pos++ // skip #
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.peek()
+		switch {
+		case c == '\n' || c == '>' || c == '|' || c == ' ' || c == '\t':
+			return p.src[start:p.pos], nil
+		case c == '[' && p.pos+1 < len(p.src) && (p.src[p.pos+1] == '"' || p.src[p.pos+1] == '\''):
+			p.pos++
+			if _, err := p.parseQuoted(); err != nil {
+				return "", err
+			}
+		default:
+			p.pos++
+		}
+	}
+	return p.src[start:p.pos], nil
+}
+
+// parseValue parses a default value, which is a quoted string, a nested tag
+// or a bare word.
+func (p *parser) parseValue(depth int) (Node, error) {
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		s, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return &TextNode{Text: s}, nil
+	case p.atTag():
+		return p.parseTag(depth + 1)
+	}
+
+	word := p.parseWord()
+	if word == "" {
+		return nil, p.unexpected("expected a default value")
+	}
+	return &TextNode{Text: word}, nil
+}
+
+// parseFilter parses a filter name followed by its arguments.
+func (p *parser) parseFilter() (*FilterNode, error) {
+	p.skipSpaces()
+	filter := &FilterNode{Pos: p.position(p.pos)}
+
+	start := p.pos
+	for isNameChar(p.peek()) {
+		p.pos++
+	}
+	filter.Name = p.src[start:p.pos]
+	if filter.Name == "" {
+		return nil, p.unexpected("expected a filter name")
+	}
+
+	for {
+		p.skipSpaces()
+		switch c := p.peek(); {
+		case c == 0 || c == '\n' || c == '|' || c == '>':
+			return filter, nil
+		case p.atDefault():
+			return nil, p.errorf(p.pos, "default value must come before filters")
+		case c == '"' || c == '\'':
+			s, err := p.parseQuoted()
+			if err != nil {
+				return nil, err
+			}
+			filter.Args = append(filter.Args, s)
+		default:
+			filter.Args = append(filter.Args, p.parseWord())
+		}
+	}
+}
+
+// parseQuoted parses a double quoted string (supporting escapes) or a single
+// quoted string (taken literally).
+func (p *parser) parseQuoted() (string, error) {
+	start := p.pos
+	quote := p.peek()
+	p.pos++
+	for p.pos < len(p.src) {
+		c := p.peek()
+		switch {
+		case c == '\n':
+			return "", p.errorf(start, "unterminated string")
+		case c == '\\' && quote == '"':
+			p.pos += 2
+		case c == quote:
+			p.pos++
+			if quote == '\'' {
+				return p.src[start+1 : p.pos-1], nil
+			}
+
+			s, err := strconv.Unquote(p.src[start:p.pos])
+			if err != nil {
+				return "", p.errorf(start, "invalid string: %v", err)
+			}
+			return s, nil
+		default:
+			p.pos++
+		}
+	}
+	return "", p.errorf(start, "unterminated string")
+}
+
+// parseWord parses an unquoted word ending at whitespace, '|' or '>'.
+func (p *parser) parseWord() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.peek()
+		if c == ' ' || c == '\t' || c == '\n' || c == '|' || c == '>' {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *parser) unexpected(context string) error {
+	if p.pos >= len(p.src) || p.peek() == '\n' {
+		return p.errorf(p.tagStart, "unterminated tag: %s", context)
+	}
+	return p.errorf(p.pos, "unexpected %q: %s", p.src[p.pos], context)
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
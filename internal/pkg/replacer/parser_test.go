@@ -0,0 +1,168 @@
+package replacer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aar10n/replacer/internal/pkg/providers"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func staticKey(tag *TagNode) string {
+	key, _ := tag.StaticKey()
+	return key
+}
+
+var _ = Describe("Parse", func() {
+	It("should parse text without tags", func() {
+		for _, s := range []string{"", "hello", "<replacement>", "<replace", "a <replace b"} {
+			tmpl, err := Parse(s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tmpl.HasTags()).To(BeFalse())
+			Expect(render(tmpl)).To(Equal(s))
+		}
+	})
+
+	It("should parse all parts of a tag", func() {
+		tmpl, err := Parse(`a <replace(gcp)?: my-key#.data[0] ?? "none" | indent 4 | bcrypt '10'> b`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tmpl.Nodes).To(HaveLen(3))
+
+		tag := tmpl.Tags()[0]
+		Expect(tag.Pos).To(Equal(Position{Offset: 2, Line: 1, Column: 3}))
+		Expect(tag.Raw).To(Equal(`<replace(gcp)?: my-key#.data[0] ?? "none" | indent 4 | bcrypt '10'>`))
+		Expect(tag.Provider).To(Equal("gcp"))
+		Expect(tag.Optional).To(BeTrue())
+		Expect(staticKey(tag)).To(Equal("my-key"))
+		Expect(tag.Selector).To(Equal(".data[0]"))
+		Expect(tag.Default).To(Equal(&TextNode{Text: "none"}))
+		Expect(tag.Filters).To(HaveLen(2))
+		Expect(tag.Filters[0].Name).To(Equal("indent"))
+		Expect(tag.Filters[0].Args).To(Equal([]string{"4"}))
+		Expect(tag.Filters[1].Name).To(Equal("bcrypt"))
+		Expect(tag.Filters[1].Args).To(Equal([]string{"10"}))
+	})
+
+	It("should parse quoted keys", func() {
+		tmpl, err := Parse(`<replace:"a>b|c\"d"> <replace:'x ?? y'>`)
+		Expect(err).ToNot(HaveOccurred())
+
+		tags := tmpl.Tags()
+		Expect(tags).To(HaveLen(2))
+		Expect(staticKey(tags[0])).To(Equal(`a>b|c"d`))
+		Expect(staticKey(tags[1])).To(Equal(`x ?? y`))
+	})
+
+	It("should parse nested tags", func() {
+		tmpl, err := Parse(`<replace:app-<replace(k8s):env>-db ?? <replace:fallback>>`)
+		Expect(err).ToNot(HaveOccurred())
+
+		tag := tmpl.Tags()[0]
+		_, static := tag.StaticKey()
+		Expect(static).To(BeFalse())
+		Expect(tag.Key).To(HaveLen(3))
+		Expect(tag.Key[0]).To(Equal(&TextNode{Text: "app-"}))
+		Expect(tag.Key[1].(*TagNode).Provider).To(Equal("k8s"))
+		Expect(tag.Key[2]).To(Equal(&TextNode{Text: "-db"}))
+		Expect(staticKey(tag.Default.(*TagNode))).To(Equal("fallback"))
+	})
+
+	It("should unescape escaped tags", func() {
+		tmpl, err := Parse(`<<replace:key> <<replace(gcp)?:key> <<replace <<replacement`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tmpl.HasTags()).To(BeFalse())
+		Expect(render(tmpl)).To(Equal(`<replace:key> <replace(gcp)?:key> <<replace <<replacement`))
+	})
+
+	DescribeTable("should report malformed tags",
+		func(s string, msg string) {
+			_, err := Parse(s)
+			var perr *ParseError
+			Expect(errors.As(err, &perr)).To(BeTrue())
+			Expect(err.Error()).To(Equal(msg))
+		},
+		Entry("unterminated tag", "a\nb <replace:key", "line 2, column 3: unterminated tag: expected '>'"),
+		Entry("tag across lines", "<replace:key\n>", "line 1, column 1: unterminated tag: expected '>'"),
+		Entry("missing key", "x <replace:>", "line 1, column 12: missing key"),
+		Entry("missing provider", "<replace():key>", "line 1, column 10: missing provider name"),
+		Entry("bad provider", "<replace(a b):key>", "line 1, column 11: unexpected ' ': in provider name"),
+		Entry("missing colon", "<replace(gcp)key>", "line 1, column 14: unexpected 'k': expected ':'"),
+		Entry("unterminated string", `<replace:"key>`, "line 1, column 10: unterminated string"),
+		Entry("missing filter", "<replace:key | >", "line 1, column 16: unexpected '>': expected a filter name"),
+		Entry("missing default", "<replace:key ?? >", "line 1, column 17: unexpected '>': expected a default value"),
+		Entry("default after filter", `<replace:key | upper ?? "x">`, "line 1, column 22: default value must come before filters"),
+		Entry("nested too deeply", strings.Repeat("<replace:", 10)+"k"+strings.Repeat(">", 10), "line 1, column 73: tags are nested too deeply"),
+	)
+})
+
+//
+
+var fuzzSeeds = []string{
+	"",
+	"plain text",
+	"<replace:key>",
+	"<<replace:key>",
+	`<replace(fuzz)?:"a>b"#.x[0] ?? 'def' | upper | indent 2>`,
+	"<replace:a-<replace:b>-c ?? <replace:d>>",
+	"<replace:key | b64enc | b64dec>",
+	"<replace:key\n>",
+	`<replace:"unterminated>`,
+	"<replace:" + strings.Repeat("<replace:", 10),
+	"\xff\x00<replace:key>\xfe<",
+}
+
+func init() {
+	providers.Register("fuzz", func() (providers.ValueProvider, error) {
+		return providers.NewTestProvider(map[string]string{
+			"key":  "value",
+			"json": `{"x":["y"]}`,
+		}), nil
+	})
+}
+
+// FuzzParse checks that the parser never panics and that templates without
+// tags render back to their source.
+func FuzzParse(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		tmpl, err := Parse(s)
+		if err != nil {
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("unexpected error type: %v", err)
+			} else if perr.Pos.Offset < 0 || perr.Pos.Offset > len(s) {
+				t.Fatalf("error position out of range: %v", err)
+			}
+			return
+		}
+
+		if !strings.Contains(s, tagPrefix) && render(tmpl) != s {
+			t.Fatalf("template without tags did not render to its source: %q", s)
+		}
+	})
+}
+
+// FuzzReplaceAll checks that replacing arbitrary input never panics and that
+// input without tags is returned unchanged, including binary data.
+func FuzzReplaceAll(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		r, err := New(map[string]string{replacerKeyPrefix + "provider": "fuzz"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		res, err := r.ReplaceAll(ctx, s)
+		if err == nil && !strings.Contains(s, tagPrefix) && res != s {
+			t.Fatalf("input without tags was changed: %q -> %q", s, res)
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package replacer
+
+import (
+	"context"
+
+	"github.com/aar10n/replacer/internal/pkg/filters"
+)
+
+// filterCall is a filter in the pipeline of a tag, e.g. "indent 4".
+type filterCall struct {
+	name   string
+	args   []string
+	filter filters.Filter
+}
+
+// applyFilters applies the filters to the value in order. It stops once ctx
+// is done, since filters like bcrypt take a while and count against the time
+// of the request.
+func applyFilters(ctx context.Context, value string, calls []filterCall) (string, error) {
+	for _, call := range calls {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		var err error
+		value, err = call.filter(value, call.args...)
+		if err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
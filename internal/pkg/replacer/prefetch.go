@@ -0,0 +1,221 @@
+package replacer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/providers"
+)
+
+// valueKey identifies a value of a provider.
+type valueKey struct {
+	provider *providers.Provider
+	key      string
+}
+
+// valueResult is the result of fetching a value.
+type valueResult struct {
+	value string
+	err   error
+}
+
+// valueFor returns the value for a key of a provider. Values and errors are
+// remembered for the lifetime of the replacer, so that each key is only
+// requested once even if it fails.
+func (r *Replacer) valueFor(ctx context.Context, p *providers.Provider, key string) (string, error) {
+	vk := valueKey{provider: p, key: key}
+	if res, ok := r.getValue(vk); ok {
+		return res.value, res.err
+	} else if err := r.checkAccess(vk); err != nil {
+		r.setValue(vk, "", err)
+		return "", err
+	}
+
+	value, err := r.call(ctx, p, key)
+	r.setValue(vk, value, err)
+	return value, err
+}
+
+// checkAccess returns an error if the authorizer denies reading a key.
+func (r *Replacer) checkAccess(vk valueKey) error {
+	if r.authorize == nil {
+		return nil
+	}
+
+	err := r.authorize(vk.provider.Name, r.configs[vk.provider.Name], vk.key)
+	if err != nil && ierrors.CodeOf(err) == "" {
+		err = ierrors.Wrap(ierrors.PermissionDenied, err)
+	}
+	return err
+}
+
+// call requests a value from a provider once the rate limiter allows it.
+func (r *Replacer) call(ctx context.Context, p *providers.Provider, key string) (string, error) {
+	if err := r.limiter.Wait(ctx, p.Name); err != nil {
+		return "", err
+	}
+	return p.ValueForContext(ctx, key)
+}
+
+func (r *Replacer) getValue(vk valueKey) (valueResult, bool) {
+	r.valuesLock.Lock()
+	defer r.valuesLock.Unlock()
+	res, ok := r.values[vk]
+	return res, ok
+}
+
+func (r *Replacer) setValue(vk valueKey, value string, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	r.valuesLock.Lock()
+	defer r.valuesLock.Unlock()
+	r.values[vk] = valueResult{value: value, err: err}
+}
+
+// collectStatic returns all replacements with static keys, including nested ones.
+func collectStatic(rkeys []*replacement) []*replacement {
+	var static []*replacement
+	var walk func(rkey *replacement)
+	walk = func(rkey *replacement) {
+		if rkey == nil {
+			return
+		}
+		if rkey.staticKey != nil {
+			static = append(static, rkey)
+		}
+		for _, expr := range rkey.key {
+			if expr.tag != nil {
+				walk(expr.tag)
+			}
+		}
+		if rkey.fallback != nil && rkey.fallback.tag != nil {
+			walk(rkey.fallback.tag)
+		}
+	}
+
+	for _, rkey := range rkeys {
+		walk(rkey)
+	}
+	return static
+}
+
+// prefetch fetches the values of all replacements with static keys before
+// they are evaluated. Identical keys are only fetched once, and the keys of
+// providers which implement providers.BatchValueProvider are fetched with a
+// single call per provider. The results (including errors) are remembered and
+// reported when the replacements are evaluated.
+func (r *Replacer) prefetch(ctx context.Context, rkeys []*replacement) {
+	var order []valueKey
+	seen := make(map[valueKey]bool)
+	for _, rkey := range collectStatic(rkeys) {
+		vk := valueKey{provider: rkey.provider, key: *rkey.staticKey}
+		if seen[vk] {
+			continue
+		} else if _, ok := r.getValue(vk); ok {
+			continue
+		}
+		seen[vk] = true
+		if err := r.checkAccess(vk); err != nil {
+			r.setValue(vk, "", err)
+			continue
+		}
+		order = append(order, vk)
+	}
+
+	// group the keys of batch providers
+	var tasks [][]valueKey
+	batches := make(map[*providers.Provider]int)
+	for _, vk := range order {
+		if !vk.provider.SupportsBatch() {
+			tasks = append(tasks, []valueKey{vk})
+		} else if i, ok := batches[vk.provider]; ok {
+			tasks[i] = append(tasks[i], vk)
+		} else {
+			batches[vk.provider] = len(tasks)
+			tasks = append(tasks, []valueKey{vk})
+		}
+	}
+
+	// limit the concurrent calls to each provider
+	sems := make(map[*providers.Provider]chan struct{})
+	for _, task := range tasks {
+		p := task[0].provider
+		if n, ok := r.providerConcurrency[p.Name]; ok && sems[p] == nil {
+			sems[p] = make(chan struct{}, n)
+		}
+	}
+
+	// fetch the values with a pool of workers
+	workers := r.maxConcurrency
+	if workers > len(tasks) {
+		workers = len(tasks)
+	}
+
+	wg := sync.WaitGroup{}
+	taskCh := make(chan []valueKey)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskCh {
+				r.fetch(ctx, task, sems[task[0].provider])
+			}
+		}()
+	}
+
+feed:
+	for _, task := range tasks {
+		select {
+		case taskCh <- task:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(taskCh)
+	wg.Wait()
+}
+
+// fetch fetches the values for the keys of a single provider and remembers
+// them. If fetching a batch fails, the keys are fetched one at a time so that
+// each key gets its own error. The semaphore limits the concurrent calls to
+// the provider (optional).
+func (r *Replacer) fetch(ctx context.Context, keys []valueKey, sem chan struct{}) {
+	p := keys[0].provider
+	if sem != nil {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	if len(keys) > 1 && r.limiter.Wait(ctx, p.Name) == nil {
+		names := make([]string, len(keys))
+		for i, vk := range keys {
+			names[i] = vk.key
+		}
+
+		values, err := p.ValuesFor(ctx, names)
+		if err == nil {
+			for _, vk := range keys {
+				if value, ok := values[vk.key]; ok {
+					r.setValue(vk, value, nil)
+				} else {
+					r.setValue(vk, "", fmt.Errorf("%w: %s", providers.ErrNotFound, vk.key))
+				}
+			}
+			return
+		}
+	}
+
+	for _, vk := range keys {
+		value, err := r.call(ctx, p, vk.key)
+		r.setValue(vk, value, err)
+	}
+}
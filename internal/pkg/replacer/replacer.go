@@ -2,59 +2,192 @@ package replacer
 
 import (
 	"context"
-	"regexp"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
 
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/filters"
 	"github.com/aar10n/replacer/internal/pkg/providers"
 	"github.com/aar10n/replacer/pkg/config"
 )
 
 const (
 	replacerKeyPrefix = "replacer.agb.dev/"
-	asyncThreshold    = 10 // prefetch async if more than 10 items
-)
+	// maxConcurrencyKey is the provider option that limits the number of
+	// concurrent calls to a provider.
+	maxConcurrencyKey = "max_concurrency"
 
-var (
-	replacerRegexPattern = regexp.MustCompile(`<replace(?:\(([a-z_-]+)\))?:([^\n>]+)>`)
+	// DefaultMaxConcurrency is the default number of values that are fetched
+	// concurrently by a replacer.
+	DefaultMaxConcurrency = 10
 )
 
+// entry is a parsed and compiled data entry.
+type entry struct {
+	name  string
+	src   string
+	tmpl  *Template
+	rkeys []*replacement
+}
+
+// replacement is a compiled replacement tag.
 type replacement struct {
-	full     string
-	key      string
-	provider *providers.Provider
+	entry     string
+	tag       *TagNode
+	key       []valueExpr
+	staticKey *string
+	selector  selector
+	fallback  *valueExpr
+	filters   []filterCall
+	provider  *providers.Provider
+	required  bool // false for tags in default values
 }
 
+// valueExpr is either literal text or a nested replacement.
+type valueExpr struct {
+	text string
+	tag  *replacement
+}
+
+// unknownKeyMode controls what happens when a key is not found.
+type unknownKeyMode int
+
+const (
+	unknownKeyFail  unknownKeyMode = iota // fail the replacement
+	unknownKeyKeep                        // leave the tag in place
+	unknownKeyEmpty                       // replace the tag with an empty string
+)
+
 // Replacer performs replacement on strings using various providers.
 type Replacer struct {
-	config    *Config
-	rawConfig map[string]string
-	providers map[string]*providers.Provider
+	config       *Config
+	escapeFormat escapeFormat
+	unknownKeys  unknownKeyMode
+	rawConfig    map[string]string
+	namespace    string
+	pool         *providers.Pool
+	limiter      *providers.RateLimiter
+	authorize    AuthorizeFunc
+	providers    map[string]*providers.Provider
+	configs      map[string]map[string]string // provider options set on the resource
+
+	maxConcurrency      int
+	providerConcurrency map[string]int
+
+	valuesLock sync.Mutex
+	values     map[valueKey]valueResult
+}
+
+// Option configures a replacer.
+type Option func(r *Replacer)
+
+// WithNamespace sets the namespace of the resource being replaced. It is passed
+// to all providers that implement providers.NamespaceAware.
+func WithNamespace(namespace string) Option {
+	return func(r *Replacer) {
+		r.namespace = namespace
+	}
+}
+
+// WithPool makes the replacer take providers from the given pool instead of
+// creating its own instances.
+func WithPool(pool *providers.Pool) Option {
+	return func(r *Replacer) {
+		r.pool = pool
+	}
+}
+
+// WithMaxConcurrency sets the maximum number of values that are fetched
+// concurrently. The max_concurrency option can only lower it.
+func WithMaxConcurrency(n int) Option {
+	return func(r *Replacer) {
+		r.maxConcurrency = n
+	}
+}
+
+// WithRateLimiter makes the replacer wait for the given rate limiter before
+// each call to a provider.
+func WithRateLimiter(limiter *providers.RateLimiter) Option {
+	return func(r *Replacer) {
+		r.limiter = limiter
+	}
+}
+
+// AuthorizeFunc decides whether a key of a provider may be read. The config
+// holds the options of the provider set on the resource, without the provider
+// prefix, which may change where the key is read from (e.g. the namespace or
+// mount of vault). It returns an error with the reason if it may not.
+type AuthorizeFunc func(provider string, config map[string]string, key string) error
+
+// WithAuthorizer makes the replacer check every key with the given function
+// before requesting its value. Denied keys fail with a permission denied
+// error.
+func WithAuthorizer(fn AuthorizeFunc) Option {
+	return func(r *Replacer) {
+		r.authorize = fn
+	}
 }
 
 // Config holds global replacer configuration options.
 type Config struct {
 	// Provider is the name of the default provider to use.
 	Provider string `config:"provider"`
-	// QuoteReplacements will quote replacement values.
-	QuoteReplacements bool `config:"escape_replacements"`
-	// IgnoreUnknownKeys will ignore unknown replacement keys.
-	IgnoreUnknownKeys bool `config:"ignore_unknown_keys"`
+	// EscapeReplacements will escape replacement values for the format of the
+	// surrounding text. It is one of "json", "yaml", "shell", or "true" to infer
+	// the format from the extension of the data key.
+	EscapeReplacements string `config:"escape_replacements"`
+	// IgnoreUnknownKeys will ignore unknown replacement keys. It is either "true"
+	// to leave the tag in place, or "empty" to replace it with an empty string.
+	IgnoreUnknownKeys string `config:"ignore_unknown_keys"`
+	// MaxConcurrency is the maximum number of values that are fetched
+	// concurrently. The number of concurrent calls to a single provider can
+	// be limited with the max_concurrency option of the provider.
+	MaxConcurrency int `config:"max_concurrency"`
 }
 
 // New creates a new replacer with the given config.
-func New(cfg map[string]string) (*Replacer, error) {
+func New(cfg map[string]string, opts ...Option) (*Replacer, error) {
 	c := &Config{}
 	err := config.LoadFromMapP(cfg, replacerKeyPrefix, c)
 	if err != nil {
 		return nil, err
 	}
 
+	format, err := parseEscapeFormat(c.EscapeReplacements)
+	if err != nil {
+		return nil, err
+	}
+
+	unknownKeys, err := parseUnknownKeyMode(c.IgnoreUnknownKeys)
+	if err != nil {
+		return nil, err
+	}
+
 	r := &Replacer{
-		config:    c,
-		rawConfig: cfg,
-		providers: make(map[string]*providers.Provider),
+		config:       c,
+		escapeFormat: format,
+		unknownKeys:  unknownKeys,
+		rawConfig:    cfg,
+		providers:    make(map[string]*providers.Provider),
+		configs:      make(map[string]map[string]string),
+		values:       make(map[valueKey]valueResult),
+
+		maxConcurrency:      DefaultMaxConcurrency,
+		providerConcurrency: make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if c.MaxConcurrency > 0 && (r.maxConcurrency <= 0 || c.MaxConcurrency < r.maxConcurrency) {
+		r.maxConcurrency = c.MaxConcurrency
+	}
+	if r.maxConcurrency <= 0 {
+		r.maxConcurrency = 1
 	}
 
 	// instantiate default provider
@@ -69,33 +202,335 @@ func New(cfg map[string]string) (*Replacer, error) {
 }
 
 // ReplaceAll replaces all replacement tags in the given string with
-// values from the corresponding providers.
-func (r *Replacer) ReplaceAll(s string) (string, error) {
-	// collect all replacement candidates
-	rkeys, err := r.getReplacementKeys(s)
-	if err != nil {
-		return "", err
-	} else if rkeys == nil || len(rkeys) == 0 {
-		return s, nil
+// values from the corresponding providers. If a tag has a selector
+// (e.g. <replace:key#.field>), the value is parsed as JSON or YAML and
+// the selected element is used instead. Finally, any filters in the tag
+// (e.g. <replace:key | b64enc>) are applied to the value in order.
+//
+// A tag may have a default value (e.g. <replace:key ?? "default">) or be
+// marked as optional (e.g. <replace?:key>), in which case a missing key
+// resolves to the default value or an empty string instead of failing.
+//
+// The context is passed to the providers, and the replacement fails once
+// it is done.
+//
+// If any tags cannot be replaced, the returned error is of type Errors and
+// lists the error of every failing tag.
+func (r *Replacer) ReplaceAll(ctx context.Context, s string) (string, error) {
+	return r.ReplaceEntry(ctx, "", s)
+}
+
+// ReplaceEntry is like ReplaceAll but also takes the name of the data entry
+// that s belongs to. It is used to infer the format of s when escaping values.
+func (r *Replacer) ReplaceEntry(ctx context.Context, name string, s string) (string, error) {
+	e, errs := r.parseEntry(name, s)
+	if e == nil {
+		return "", errs.err()
+	} else if !e.tmpl.HasTags() {
+		return render(e.tmpl), nil
 	}
 
 	// prefetch replacements
-	err = r.prefetch(rkeys)
+	r.prefetch(ctx, e.rkeys)
+
+	res, rerrs := r.renderEntry(ctx, e)
+	errs = append(errs, rerrs...)
+	if len(errs) > 0 {
+		errs.sort()
+		return "", errs
+	}
+	return res, nil
+}
+
+// ReplaceMap replaces the tags in all values of the given map as with
+// ReplaceEntry, and returns a new map with the results. The values for all
+// entries are fetched together, so that a key used in several entries is
+// only requested once.
+func (r *Replacer) ReplaceMap(ctx context.Context, m map[string]string) (map[string]string, error) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs Errors
+	var entries []*entry
+	var rkeys []*replacement
+	for _, name := range names {
+		e, eerrs := r.parseEntry(name, m[name])
+		errs = append(errs, eerrs...)
+		if e != nil {
+			entries = append(entries, e)
+			rkeys = append(rkeys, e.rkeys...)
+		}
+	}
+
+	// prefetch replacements for all entries
+	r.prefetch(ctx, rkeys)
+
+	res := make(map[string]string, len(m))
+	for _, e := range entries {
+		var rerrs Errors
+		res[e.name], rerrs = r.renderEntry(ctx, e)
+		errs = append(errs, rerrs...)
+	}
+
+	if len(errs) > 0 {
+		errs.sort()
+		return nil, errs
+	}
+	return res, nil
+}
+
+// Source is the provider and key of a tag.
+type Source struct {
+	Provider string
+	Key      string
+}
+
+func (s Source) String() string {
+	return s.Provider + ":" + s.Key
+}
+
+// Sources returns the provider and key of every tag in s in order of
+// appearance, including nested tags. Nested tags in keys are left as written.
+func (r *Replacer) Sources(s string) ([]Source, error) {
+	tmpl, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []Source
+	seen := make(map[Source]bool)
+	var visit func(tag *TagNode)
+	visit = func(tag *TagNode) {
+		src := Source{Provider: tag.Provider}
+		if src.Provider == "" {
+			src.Provider = r.config.Provider
+		}
+
+		var key strings.Builder
+		var nested []*TagNode
+		for _, n := range tag.Key {
+			switch n := n.(type) {
+			case *TextNode:
+				key.WriteString(n.Text)
+			case *TagNode:
+				key.WriteString(n.Raw)
+				nested = append(nested, n)
+			}
+		}
+		if t, ok := tag.Default.(*TagNode); ok {
+			nested = append(nested, t)
+		}
+
+		src.Key = key.String()
+		if !seen[src] {
+			seen[src] = true
+			sources = append(sources, src)
+		}
+		for _, t := range nested {
+			visit(t)
+		}
+	}
+
+	for _, tag := range tmpl.Tags() {
+		visit(tag)
+	}
+	return sources, nil
+}
+
+// Input is a value read from a provider to render a template.
+type Input struct {
+	Source
+	Value string
+	// Code is the code of the error returned for the key, if any.
+	Code ierrors.Code
+}
+
+// Inputs returns the values read from the providers to render s, in order of
+// evaluation. Renders of s with the same inputs result in the same value,
+// except for filters adding random salts, so the inputs tell whether a
+// rendered value is still up to date. Values which were read by an earlier
+// replacement are not requested again.
+func (r *Replacer) Inputs(ctx context.Context, s string) ([]Input, error) {
+	e, errs := r.parseEntry("", s)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	var inputs []Input
+	for _, rkey := range e.rkeys {
+		r.collectInputs(ctx, rkey, &inputs)
+	}
+	return inputs, nil
+}
+
+// collectInputs adds the inputs of a replacement and its nested tags, as
+// they are evaluated by evaluate.
+func (r *Replacer) collectInputs(ctx context.Context, rkey *replacement, inputs *[]Input) {
+	for _, expr := range rkey.key {
+		if expr.tag != nil {
+			r.collectInputs(ctx, expr.tag, inputs)
+		}
+	}
+
+	key, err := r.evaluateExprs(ctx, rkey.key)
+	if err != nil {
+		// the failing nested tag is part of the inputs
+		return
+	}
+
+	input := Input{Source: Source{Provider: rkey.provider.Name, Key: key}}
+	val, err := r.valueFor(ctx, rkey.provider, key)
+	if err == nil {
+		input.Value = val
+		_, err = rkey.selector.apply(val)
+	} else {
+		input.Code = errorCode(err, ierrors.ProviderError)
+	}
+	*inputs = append(*inputs, input)
+
+	if err != nil && rkey.fallback != nil && rkey.fallback.tag != nil && errors.Is(err, providers.ErrNotFound) {
+		r.collectInputs(ctx, rkey.fallback.tag, inputs)
+	}
+}
+
+// Close releases all providers used by the replacer.
+func (r *Replacer) Close() {
+	for _, p := range r.providers {
+		if r.pool != nil {
+			r.pool.Put(p)
+		} else {
+			p.Close()
+		}
+	}
+	r.providers = make(map[string]*providers.Provider)
+}
+
+// parseEntry parses and compiles the template of a data entry. The entry is
+// nil if it cannot be parsed. Tags which cannot be compiled are left out of
+// the entry and returned as errors.
+func (r *Replacer) parseEntry(name string, s string) (*entry, Errors) {
+	tmpl, err := Parse(s)
+	if err != nil {
+		perr := &ParseError{}
+		errors.As(err, &perr)
+		return nil, Errors{{
+			Entry: name,
+			Pos:   perr.Pos,
+			Code:  ierrors.InvalidArgument,
+			Err:   err,
+		}}
+	}
+
+	// compile all replacement tags
+	rkeys, errs := r.compileAll(name, tmpl)
+	return &entry{name: name, src: s, tmpl: tmpl, rkeys: rkeys}, errs
+}
+
+// renderEntry evaluates the replacements of an entry and returns the result
+// along with the errors of all tags that failed.
+func (r *Replacer) renderEntry(ctx context.Context, e *entry) (string, Errors) {
+	format := r.escapeFormat
+	if format == escapeAuto {
+		format = formatForName(e.name)
+	}
+
+	var sb strings.Builder
+	var errs Errors
+	i := 0
+	for _, node := range e.tmpl.Nodes {
+		tag, ok := node.(*TagNode)
+		if !ok {
+			sb.WriteString(node.(*TextNode).Text)
+			continue
+		}
+
+		rkey := e.rkeys[i]
+		i++
+		if rkey == nil {
+			// failed to compile
+			continue
+		}
+
+		val, err := r.evaluate(ctx, rkey)
+		if r.isIgnored(err) {
+			if r.unknownKeys == unknownKeyKeep {
+				sb.WriteString(tag.Raw)
+				continue
+			}
+			val = ""
+		} else if err != nil {
+			errs = errs.add(err)
+			continue
+		}
+
+		val, err = escapeValue(format, val, e.src[:tag.Pos.Offset])
+		if err != nil {
+			key, _ := tag.StaticKey()
+			errs = append(errs, rkey.newError(key, ierrors.InvalidArgument, err))
+			continue
+		}
+		sb.WriteString(val)
+	}
+
+	return sb.String(), errs
+}
+
+// evaluate returns the final value for a replacement. Nested tags in the key
+// are evaluated first. If the key is not found and the tag has a default value,
+// the default value is used instead. Errors are returned as *Error for the
+// innermost tag that failed.
+func (r *Replacer) evaluate(ctx context.Context, rkey *replacement) (string, error) {
+	key, err := r.evaluateExprs(ctx, rkey.key)
 	if err != nil {
 		return "", err
 	}
 
-	// replace all
-	for _, rkey := range rkeys {
-		val, err := rkey.provider.ValueFor(rkey.key)
+	code := ierrors.ProviderError
+	val, err := r.valueFor(ctx, rkey.provider, key)
+	if err == nil {
+		code = ierrors.InvalidArgument
+		val, err = rkey.selector.apply(val)
+	}
+
+	if err != nil && rkey.fallback != nil && errors.Is(err, providers.ErrNotFound) {
+		val, err = r.evaluateExprs(ctx, []valueExpr{*rkey.fallback})
 		if err != nil {
 			return "", err
 		}
+	} else if err != nil {
+		return "", rkey.newError(key, code, err)
+	}
 
-		s = strings.ReplaceAll(s, rkey.full, val)
+	val, err = applyFilters(ctx, val, rkey.filters)
+	if err != nil {
+		return "", rkey.newError(key, ierrors.InvalidArgument, err)
 	}
+	return val, nil
+}
 
-	return s, nil
+func (r *Replacer) evaluateExprs(ctx context.Context, exprs []valueExpr) (string, error) {
+	var sb strings.Builder
+	for _, expr := range exprs {
+		if expr.tag == nil {
+			sb.WriteString(expr.text)
+			continue
+		}
+
+		val, err := r.evaluate(ctx, expr.tag)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(val)
+	}
+	return sb.String(), nil
+}
+
+// isIgnored returns true if err is a not found error that should be ignored.
+func (r *Replacer) isIgnored(err error) bool {
+	return err != nil && r.unknownKeys != unknownKeyFail && errors.Is(err, providers.ErrNotFound)
 }
 
 func (r *Replacer) getProvider(name string) (*providers.Provider, error) {
@@ -103,6 +538,26 @@ func (r *Replacer) getProvider(name string) (*providers.Provider, error) {
 		return p, nil
 	}
 
+	cfg := providerConfig(r.rawConfig, name)
+	if s, ok := cfg[maxConcurrencyKey]; ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s.%s value: %s", name, maxConcurrencyKey, s)
+		}
+		r.providerConcurrency[name] = n
+		delete(cfg, maxConcurrencyKey)
+	}
+	r.configs[name] = cfg
+
+	if r.pool != nil {
+		p, err := r.pool.Get(name, cfg, r.namespace)
+		if err != nil {
+			return nil, err
+		}
+		r.providers[name] = p
+		return p, nil
+	}
+
 	// instantiate provider
 	p, err := providers.Use(name)
 	if err != nil {
@@ -112,87 +567,171 @@ func (r *Replacer) getProvider(name string) (*providers.Provider, error) {
 	// load provider config
 	err = config.LoadFromMapP(r.rawConfig, replacerKeyPrefix+name+".", p.ValueProvider)
 	if err != nil {
+		p.Close()
 		return nil, err
 	}
 
+	if na, ok := p.ValueProvider.(providers.NamespaceAware); ok {
+		na.SetNamespace(r.namespace)
+	}
+
 	r.providers[name] = p
 	return p, nil
 }
 
-func (r *Replacer) getReplacementKeys(s string) ([]replacement, error) {
-	res := replacerRegexPattern.FindAllStringSubmatch(s, -1)
-	if res == nil {
-		return nil, nil
+// providerConfig returns the config of the named provider with the prefix
+// removed from the keys.
+func providerConfig(cfg map[string]string, name string) map[string]string {
+	prefix := replacerKeyPrefix + name + "."
+	pcfg := make(map[string]string)
+	for k, v := range cfg {
+		if strings.HasPrefix(k, prefix) {
+			pcfg[strings.TrimPrefix(k, prefix)] = v
+		}
 	}
+	return pcfg
+}
 
-	keys := make([]replacement, len(res))
-	for i, match := range res {
-		if match == nil || len(match) != 3 {
-			continue
+// compileAll compiles the top-level tags of the template of a data entry.
+// The replacement of a tag that fails to compile is nil.
+func (r *Replacer) compileAll(name string, tmpl *Template) ([]*replacement, Errors) {
+	var rkeys []*replacement
+	var errs Errors
+	for _, tag := range tmpl.Tags() {
+		rkey, err := r.compile(name, tag, true)
+		if err != nil {
+			errs = errs.add(err)
 		}
+		rkeys = append(rkeys, rkey)
+	}
+	return rkeys, errs
+}
 
-		providerName := match[1]
-		if providerName == "" {
-			providerName = r.config.Provider
+// compile resolves the provider, selector and filters of a tag and compiles
+// any nested tags.
+func (r *Replacer) compile(entry string, tag *TagNode, required bool) (*replacement, error) {
+	providerName := tag.Provider
+	if providerName == "" {
+		providerName = r.config.Provider
+	}
+
+	key, _ := tag.StaticKey()
+	provider, err := r.getProvider(providerName)
+	if err != nil {
+		return nil, &Error{
+			Entry:    entry,
+			Pos:      tag.Pos,
+			Provider: providerName,
+			Key:      key,
+			Code:     ierrors.InvalidProvider,
+			Err:      err,
 		}
+	}
+
+	sel, err := parseSelector(tag.Selector)
+	if err != nil {
+		return nil, &Error{
+			Entry:    entry,
+			Pos:      tag.Pos,
+			Provider: providerName,
+			Key:      key,
+			Code:     ierrors.InvalidArgument,
+			Err:      err,
+		}
+	}
 
-		key := match[2]
-		provider, err := r.getProvider(providerName)
+	rkey := &replacement{
+		entry:    entry,
+		tag:      tag,
+		selector: sel,
+		provider: provider,
+		required: required,
+	}
+
+	for _, n := range tag.Key {
+		expr, err := r.compileNode(entry, n, required)
 		if err != nil {
 			return nil, err
 		}
+		rkey.key = append(rkey.key, expr)
+	}
+	if _, ok := tag.StaticKey(); ok {
+		rkey.staticKey = &key
+	}
 
-		keys[i] = replacement{
-			full:     match[0],
-			key:      key,
-			provider: provider,
+	if tag.Default != nil {
+		expr, err := r.compileNode(entry, tag.Default, false)
+		if err != nil {
+			return nil, err
 		}
+		rkey.fallback = &expr
+	} else if tag.Optional {
+		// an optional tag defaults to an empty string
+		rkey.fallback = &valueExpr{}
 	}
-	return keys, nil
-}
 
-func (r *Replacer) prefetch(rkeys []replacement) error {
-	if len(rkeys) < asyncThreshold {
-		// prefetch synchronously
-		for _, rkey := range rkeys {
-			_, err := rkey.provider.ValueFor(rkey.key)
-			if err != nil {
-				return err
+	for _, f := range tag.Filters {
+		filter, err := filters.Get(f.Name)
+		if err != nil {
+			return nil, &Error{
+				Entry:    entry,
+				Pos:      f.Pos,
+				Provider: providerName,
+				Key:      key,
+				Code:     ierrors.InvalidArgument,
+				Err:      err,
 			}
 		}
-		return nil
+		rkey.filters = append(rkey.filters, filterCall{
+			name:   f.Name,
+			args:   f.Args,
+			filter: filter,
+		})
 	}
+	return rkey, nil
+}
 
-	// prefetch asynchronously
-	wg := sync.WaitGroup{}
-	errCh := make(chan error, len(rkeys))
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-
-	ctx, cancel = context.WithCancel(ctx)
-	defer cancel()
-
-	for _, rkey := range rkeys {
-		wg.Add(1)
-		go func(r replacement) {
-			defer wg.Done()
-			_, err := r.provider.ValueFor(r.key)
-			if err != nil {
-				cancel()
-			}
-			errCh <- err
-		}(rkey)
+func (r *Replacer) compileNode(entry string, n Node, required bool) (valueExpr, error) {
+	switch n := n.(type) {
+	case *TagNode:
+		rkey, err := r.compile(entry, n, required)
+		if err != nil {
+			return valueExpr{}, err
+		}
+		return valueExpr{tag: rkey}, nil
+	case *TextNode:
+		return valueExpr{text: n.Text}, nil
 	}
+	return valueExpr{}, fmt.Errorf("unexpected node %T", n)
+}
 
-	wg.Wait()
-	close(errCh)
-
-	for err := range errCh {
-		if err != nil {
-			return err
+// render returns the text of a template without any tags.
+func render(tmpl *Template) string {
+	var sb strings.Builder
+	for _, n := range tmpl.Nodes {
+		if text, ok := n.(*TextNode); ok {
+			sb.WriteString(text.Text)
 		}
 	}
+	return sb.String()
+}
 
-	return nil
+// KeepsUnknownKeys returns true if a value of the ignore_unknown_keys option
+// leaves the tags of unknown keys in place.
+func KeepsUnknownKeys(s string) (bool, error) {
+	mode, err := parseUnknownKeyMode(s)
+	return mode == unknownKeyKeep, err
+}
+
+// parseUnknownKeyMode parses the value of the ignore_unknown_keys option.
+func parseUnknownKeyMode(s string) (unknownKeyMode, error) {
+	switch strings.ToLower(s) {
+	case "", "false", "0":
+		return unknownKeyFail, nil
+	case "true", "1", "keep":
+		return unknownKeyKeep, nil
+	case "empty":
+		return unknownKeyEmpty, nil
+	}
+	return unknownKeyFail, fmt.Errorf("invalid ignore_unknown_keys value: %s", s)
 }
@@ -1,8 +1,17 @@
 package replacer
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/filters"
 	"github.com/aar10n/replacer/internal/pkg/providers"
 	_ "github.com/aar10n/replacer/internal/pkg/providers/gcp"
 
@@ -22,14 +31,117 @@ func makeTestProviderFactory(replacements map[string]string) providers.Factory {
 	}
 }
 
+var ctx = context.Background()
+
+// blockingProvider is a provider which never returns a value.
+type blockingProvider struct{}
+
+func (p *blockingProvider) ValueFor(key string) (string, error) {
+	select {}
+}
+
+// batchProvider counts the calls made to it.
+type batchProvider struct {
+	values     map[string]string
+	fail       bool
+	calls      int
+	batchCalls int
+	lock       sync.Mutex
+}
+
+func (p *batchProvider) ValueFor(key string) (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.calls++
+
+	if value, ok := p.values[key]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("%w: %s", providers.ErrNotFound, key)
+}
+
+func (p *batchProvider) ValuesFor(ctx context.Context, keys []string) (map[string]string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.batchCalls++
+
+	if p.fail {
+		return nil, errors.New("batch failed")
+	}
+
+	values := make(map[string]string)
+	for _, key := range keys {
+		if value, ok := p.values[key]; ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// concurrencyProvider records the maximum number of concurrent calls.
+type concurrencyProvider struct {
+	active    int32
+	maxActive int32
+}
+
+func (p *concurrencyProvider) ValueFor(key string) (string, error) {
+	n := atomic.AddInt32(&p.active, 1)
+	defer atomic.AddInt32(&p.active, -1)
+	for {
+		max := atomic.LoadInt32(&p.maxActive)
+		if n <= max || atomic.CompareAndSwapInt32(&p.maxActive, max, n) {
+			break
+		}
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	return key, nil
+}
+
+// contextProvider returns the key as the value until the context is done.
+type contextProvider struct{}
+
+func (p *contextProvider) ValueFor(key string) (string, error) {
+	return p.ValueForContext(context.Background(), key)
+}
+
+func (p *contextProvider) ValueForContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// filterCalls counts the calls of the test-cancel filter, which calls
+// cancelFilter.
+var (
+	filterCalls  int
+	cancelFilter func()
+)
+
+func init() {
+	filters.Register("test-cancel", func(value string, args ...string) (string, error) {
+		filterCalls++
+		cancelFilter()
+		return value, nil
+	})
+}
+
 var _ = Describe("Replacer", func() {
 	Describe("ReplaceAll", func() {
 		providers.Register("test",
 			makeTestProviderFactory(map[string]string{
-				"key1": "value1",
-				"key2": "value2",
-				"key3": "value3",
-				"key4": "value4",
+				"key1":    "value1",
+				"key2":    "value2",
+				"key3":    "value3",
+				"key4":    "value4",
+				"special": "it's a \"quoted\" $value\nline2",
+				"json":    `{"credentials":{"user":"admin","password":"hunter2"},"hosts":["a","b"],"port":5432,"a.b":"dotted"}`,
+				"null":    `{"a":null}`,
+				"yaml":    "credentials:\n  user: admin\n  password: hunter2\nhosts:\n  - a\n  - b\n",
+				"index":   "3",
+				"a>b":     "gt",
+				"binary":  "/wD+YQ==",
 			}),
 		)
 
@@ -39,7 +151,7 @@ var _ = Describe("Replacer", func() {
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			res, err := r.ReplaceAll(`
+			res, err := r.ReplaceAll(ctx, `
 				hello <replace:key1> <replace:key2>
 				<replace:key2> test
 				more <replace:key3> <replace:key3>
@@ -61,7 +173,7 @@ var _ = Describe("Replacer", func() {
 			r, err := New(map[string]string{})
 			Expect(err).ToNot(HaveOccurred())
 
-			res, err := r.ReplaceAll("<replace(test):key1>")
+			res, err := r.ReplaceAll(ctx, "<replace(test):key1>")
 			Expect(err).ToNot(HaveOccurred())
 			Expect(res).To(Equal("value1"))
 		})
@@ -70,11 +182,769 @@ var _ = Describe("Replacer", func() {
 			r, err := New(map[string]string{})
 			Expect(err).ToNot(HaveOccurred())
 
-			_, err = r.ReplaceAll("<replace:key1>")
+			_, err = r.ReplaceAll(ctx, "<replace:key1>")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ReplaceAll (selectors)", func() {
+		It("should select fields from json values", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:json#.credentials.password> <replace:json#.hosts[1]> <replace:json#.port> <replace:json#["a.b"]>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("hunter2 b 5432 dotted"))
+		})
+
+		It("should select fields from yaml values", func() {
+			r, err := New(map[string]string{})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace(test):yaml#.credentials.user>:<replace(test):yaml#.credentials.password>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("admin:hunter2"))
+		})
+
+		It("should return objects and arrays as json", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:yaml#.credentials>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(MatchJSON(`{"user":"admin","password":"hunter2"}`))
+
+			res, err = r.ReplaceAll(ctx, `<replace:json#.hosts>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal(`["a","b"]`))
+		})
+
+		It("should return an error if the path is missing", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, `<replace:json#.credentials.token>`)
+			Expect(err).To(MatchError(ContainSubstring("field .credentials.token not found")))
+
+			_, err = r.ReplaceAll(ctx, `<replace:json#.hosts[5]>`)
+			Expect(err).To(MatchError(ContainSubstring("out of range")))
+
+			_, err = r.ReplaceAll(ctx, `<replace:key1#.field>`)
+			Expect(err).To(HaveOccurred())
+		})
+
+		DescribeTable("parseSelector",
+			func(s string, expected string, valid bool) {
+				sel, err := parseSelector(s)
+				if !valid {
+					Expect(err).To(HaveOccurred())
+					return
+				}
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sel.String()).To(Equal(expected))
+			},
+			Entry(nil, ".a.b.c", ".a.b.c", true),
+			Entry(nil, ".a[0].b", ".a[0].b", true),
+			Entry(nil, `["a.b"].c`, `["a.b"].c`, true),
+			Entry(nil, `.['a]b']`, `["a]b"]`, true),
+			Entry(nil, ".a..b", "", false),
+			Entry(nil, ".a[x]", "", false),
+			Entry(nil, ".a[-1]", "", false),
+			Entry(nil, `.["a`, "", false),
+			Entry(nil, "a", "", false),
+		)
+	})
+
+	Describe("ReplaceAll (filters)", func() {
+		It("should apply filters in order", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:key1 | b64enc> <replace:key1|upper|b64enc> <replace:key2 | sha256>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("dmFsdWUx VkFMVUUx 0537d481f73a757334328052da3af9626ced97028e20b849f6115c22cd765197"))
+		})
+
+		It("should apply filters after selectors", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:json#.credentials.password | upper>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("HUNTER2"))
+		})
+
+		It("should pass quoted arguments to filters", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:key1 | htpasswd "my user">`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(HavePrefix("my user:$2y$"))
+		})
+
+		It("should return an error for unknown filters", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, `<replace:key1 | unknown>`)
+			Expect(err).To(HaveOccurred())
+			_, err = r.ReplaceAll(ctx, `<replace:key1 | >`)
+			Expect(err).To(HaveOccurred())
+			_, err = r.ReplaceAll(ctx, `<replace:key1 | indent "4>`)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should stop applying filters once the context is done", func() {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			filterCalls, cancelFilter = 0, cancel
+
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, `<replace:key1 | test-cancel | test-cancel | bcrypt>`)
+			Expect(errors.Is(err, ierrors.ErrTimeout)).To(BeTrue(), "%v", err)
+			Expect(filterCalls).To(Equal(1))
+		})
+	})
+
+	Describe("ReplaceAll (escape_replacements)", func() {
+		It("should escape values in json", func() {
+			r, err := New(map[string]string{
+				replacerKeyPrefix + "provider":            "test",
+				replacerKeyPrefix + "escape_replacements": "json",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `{"a": "<replace:special>", "b": <replace:special>}`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(MatchJSON(`{"a": "it's a \"quoted\" $value\nline2", "b": "it's a \"quoted\" $value\nline2"}`))
+		})
+
+		It("should escape values in yaml", func() {
+			r, err := New(map[string]string{
+				replacerKeyPrefix + "provider":            "test",
+				replacerKeyPrefix + "escape_replacements": "yaml",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, "a: <replace:special>\nb: \"<replace:special>\"\nc: |\n  <replace:special>\nd: 'x <replace:key1>'\n")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("a: \"it's a \\\"quoted\\\" $value\\nline2\"\n" +
+				"b: \"it's a \\\"quoted\\\" $value\\nline2\"\n" +
+				"c: |\n  it's a \"quoted\" $value\n  line2\n" +
+				"d: 'x value1'\n"))
+
+			_, err = r.ReplaceAll(ctx, "a: '<replace:special>'")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should escape values in shell scripts", func() {
+			r, err := New(map[string]string{
+				replacerKeyPrefix + "provider":            "test",
+				replacerKeyPrefix + "escape_replacements": "shell",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `A=<replace:special>; B="<replace:special>"; C='<replace:special>'`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("A='it'\\''s a \"quoted\" $value\nline2'; " +
+				"B=\"it's a \\\"quoted\\\" \\$value\nline2\"; " +
+				"C='it'\\''s a \"quoted\" $value\nline2'"))
+		})
+
+		It("should infer the format from the entry name", func() {
+			r, err := New(map[string]string{
+				replacerKeyPrefix + "provider":            "test",
+				replacerKeyPrefix + "escape_replacements": "true",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceEntry(ctx, "config.json", `{"a": "<replace:special>"}`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(MatchJSON(`{"a": "it's a \"quoted\" $value\nline2"}`))
+
+			res, err = r.ReplaceEntry(ctx, "password", `<replace:special>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("it's a \"quoted\" $value\nline2"))
+		})
+
+		It("should not escape values by default", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceEntry(ctx, "config.json", `{"a": "<replace:special>"}`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal(`{"a": "it's a "quoted" $value` + "\n" + `line2"}`))
+		})
+
+		It("should return an error for an invalid value", func() {
+			_, err := New(map[string]string{replacerKeyPrefix + "escape_replacements": "xml"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should not escape binary values", func() {
+			r, err := New(map[string]string{
+				replacerKeyPrefix + "provider":            "test",
+				replacerKeyPrefix + "escape_replacements": "json",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, `{"x": <replace:binary | b64dec>}`)
+			Expect(err).To(MatchError(ContainSubstring("cannot escape a binary value for json")))
+		})
+	})
+
+	Describe("ReplaceAll (binary)", func() {
+		It("should keep binary values and text intact", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, "<replace:binary | b64dec>")
+			Expect(err).ToNot(HaveOccurred())
+			Expect([]byte(res)).To(Equal([]byte{0xff, 0x00, 0xfe, 'a'}))
+
+			res, err = r.ReplaceAll(ctx, "\xff\x00<replace:key1>\xc3<")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("\xff\x00value1\xc3<"))
+		})
+	})
+
+	Describe("ReplaceAll (ignore_unknown_keys)", func() {
+		It("should fail on unknown keys by default", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, `<replace:key1> <replace:unknown>`)
+			Expect(err).To(MatchError(providers.ErrNotFound))
+		})
+
+		It("should leave unknown tags in place", func() {
+			r, err := New(map[string]string{
+				replacerKeyPrefix + "provider":            "test",
+				replacerKeyPrefix + "ignore_unknown_keys": "true",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:key1> <replace:unknown> <replace:json#.missing>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal(`value1 <replace:unknown> <replace:json#.missing>`))
+		})
+
+		It("should replace unknown tags with empty strings", func() {
+			r, err := New(map[string]string{
+				replacerKeyPrefix + "provider":            "test",
+				replacerKeyPrefix + "ignore_unknown_keys": "empty",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:key1>:<replace:unknown>:<replace:key2>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal(`value1::value2`))
+		})
+
+		It("should not ignore other errors", func() {
+			r, err := New(map[string]string{
+				replacerKeyPrefix + "provider":            "test",
+				replacerKeyPrefix + "ignore_unknown_keys": "true",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, `<replace:key1 | indent x>`)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error for an invalid value", func() {
+			_, err := New(map[string]string{replacerKeyPrefix + "ignore_unknown_keys": "sometimes"})
 			Expect(err).To(HaveOccurred())
 		})
 	})
 
+	Describe("ReplaceAll (defaults)", func() {
+		It("should use the default value for missing keys", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:feature-flag ?? "off"> <replace:key1 ?? "off"> <replace:missing ?? 'a | b'>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("off value1 a | b"))
+		})
+
+		It("should use the default value for missing selector paths", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:json#.credentials.token ?? none>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("none"))
+
+			res, err = r.ReplaceAll(ctx,
+				`<replace:json#.hosts[5] ?? a> <replace:json#.port.x ?? b> <replace:json#.hosts.x ?? c> <replace:json#.credentials[0] ?? d> <replace:null#.a.b ?? e>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("a b c d e"))
+		})
+
+		It("should not use the default value for unstructured values", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, `<replace:key1#.field ?? none>`)
+			Expect(err).To(MatchError(ContainSubstring("value is not an object or array")))
+		})
+
+		It("should apply filters to the default value", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:missing ?? "off" | upper>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("OFF"))
+		})
+
+		It("should replace optional tags with an empty string", func() {
+			r, err := New(map[string]string{})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `[<replace(test)?:optional-secret>] [<replace(test)?:key1>]`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("[] [value1]"))
+
+			r, err = New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err = r.ReplaceAll(ctx, `[<replace?:optional-secret>]`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("[]"))
+		})
+
+		It("should return errors other than not found", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, `<replace?:key1#.field>`)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error for invalid defaults", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, `<replace:missing ?? a b>`)
+			Expect(err).To(HaveOccurred())
+			_, err = r.ReplaceAll(ctx, `<replace:missing ??>`)
+			Expect(err).To(HaveOccurred())
+			_, err = r.ReplaceAll(ctx, `<replace:missing | upper ?? "off">`)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ReplaceAll (nesting and escapes)", func() {
+		It("should resolve nested tags in keys and defaults", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:key<replace:index>> <replace:missing ?? <replace:key2>>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("value3 value2"))
+		})
+
+		It("should leave escaped tags as literal text", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<<replace:key1> <replace:key1>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("<replace:key1> value1"))
+		})
+
+		It("should support quoted keys", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:"a>b"> <replace:'key1'>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("gt value1"))
+		})
+
+		It("should return the sources of all tags", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			sources, err := r.Sources(`<replace:key<replace(other):index>> <replace:missing ?? <replace:key2>> <replace:key2 | upper>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sources).To(Equal([]Source{
+				{Provider: "test", Key: "key<replace(other):index>"},
+				{Provider: "other", Key: "index"},
+				{Provider: "test", Key: "missing"},
+				{Provider: "test", Key: "key2"},
+			}))
+		})
+
+		It("should return the inputs of a template", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			tmpl := `<replace:key<replace:index>> <replace:missing ?? <replace:key1>> <replace:json#.x ?? <replace:key2>> <replace:key2 ?? <replace:key4>> <replace:missing ?? x>`
+			_, err = r.ReplaceAll(ctx, tmpl)
+			Expect(err).ToNot(HaveOccurred())
+
+			inputs, err := r.Inputs(ctx, tmpl)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(inputs).To(Equal([]Input{
+				{Source: Source{Provider: "test", Key: "index"}, Value: "3"},
+				{Source: Source{Provider: "test", Key: "key3"}, Value: "value3"},
+				{Source: Source{Provider: "test", Key: "missing"}, Code: ierrors.NotFound},
+				{Source: Source{Provider: "test", Key: "key1"}, Value: "value1"},
+				{Source: Source{Provider: "test", Key: "json"}, Value: `{"credentials":{"user":"admin","password":"hunter2"},"hosts":["a","b"],"port":5432,"a.b":"dotted"}`},
+				{Source: Source{Provider: "test", Key: "key2"}, Value: "value2"},
+				{Source: Source{Provider: "test", Key: "key2"}, Value: "value2"},
+				{Source: Source{Provider: "test", Key: "missing"}, Code: ierrors.NotFound},
+			}))
+
+			_, err = r.Inputs(ctx, "<replace:key1 | nope>")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should report the position of malformed tags", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, "line one\n  <replace:key1")
+			Expect(err).To(MatchError(ContainSubstring("line 2, column 3: unterminated tag")))
+		})
+	})
+
+	Describe("ReplaceAll (context)", func() {
+		providers.Register("blocking", func() (providers.ValueProvider, error) {
+			return &blockingProvider{}, nil
+		})
+		providers.Register("context", func() (providers.ValueProvider, error) {
+			return &contextProvider{}, nil
+		})
+
+		It("should stop waiting for providers when the context is done", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "blocking"})
+			Expect(err).ToNot(HaveOccurred())
+
+			ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+
+			_, err = r.ReplaceAll(ctx, `<replace:key1>`)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+
+		It("should pass the context to context aware providers", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "context"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, `<replace:key1>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("key1"))
+
+			ctx, cancel := context.WithCancel(ctx)
+			cancel()
+
+			_, err = r.ReplaceAll(ctx, `<replace:key2>`)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+	})
+
+	Describe("ReplaceMap", func() {
+		var batch *batchProvider
+		providers.Register("batch", func() (providers.ValueProvider, error) {
+			return batch, nil
+		})
+
+		BeforeEach(func() {
+			batch = &batchProvider{values: map[string]string{"a": "1", "b": "2", "c": "3"}}
+		})
+
+		It("should replace all entries", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceMap(ctx, map[string]string{
+				"one":   "<replace:key1>",
+				"two":   "<replace:key1>-<replace:key2>",
+				"plain": "<<replace:x>",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal(map[string]string{
+				"one":   "value1",
+				"two":   "value1-value2",
+				"plain": "<replace:x>",
+			}))
+		})
+
+		It("should fetch the keys of all entries with one batch", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "batch"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceMap(ctx, map[string]string{
+				"x": "<replace:a> <replace:b>",
+				"y": "<replace:a> <replace:c> <replace:missing ?? d>",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal(map[string]string{"x": "1 2", "y": "1 3 d"}))
+			Expect(batch.batchCalls).To(Equal(1))
+			Expect(batch.calls).To(Equal(0))
+		})
+
+		It("should fetch keys one at a time if the batch fails", func() {
+			batch.fail = true
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "batch"})
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceMap(ctx, map[string]string{
+				"x": "<replace:a> <replace:b>",
+				"y": "<replace:a>",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal(map[string]string{"x": "1 2", "y": "1"}))
+			Expect(batch.batchCalls).To(Equal(1))
+			Expect(batch.calls).To(Equal(2))
+		})
+
+		It("should report the entry of a missing key", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "batch"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceMap(ctx, map[string]string{
+				"x": "<replace:a>",
+				"y": "\n <replace:b> <replace:missing>",
+			})
+			Expect(err).To(MatchError(ContainSubstring("y: line 2, column 14: not found")))
+			Expect(errors.Is(err, providers.ErrNotFound)).To(BeTrue())
+		})
+
+		It("should report the errors of all failing tags", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "batch"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceMap(ctx, map[string]string{
+				"x": "<replace:a | nope> <replace:missing1>",
+				"y": "<replace:b> <replace:missing2 ?? ok>\n<replace:missing3#.field>",
+				"z": "<replace:a",
+			})
+			Expect(err).To(HaveOccurred())
+
+			var errs Errors
+			Expect(errors.As(err, &errs)).To(BeTrue())
+			Expect(errs).To(HaveLen(4))
+
+			Expect(errs[0].Entry).To(Equal("x"))
+			Expect(errs[0].Pos.Column).To(Equal(14))
+			Expect(errs[0].Code).To(Equal(ierrors.InvalidArgument))
+
+			Expect(errs[1].Entry).To(Equal("x"))
+			Expect(errs[1].Provider).To(Equal("batch"))
+			Expect(errs[1].Key).To(Equal("missing1"))
+			Expect(errs[1].Code).To(Equal(ierrors.NotFound))
+
+			Expect(errs[2].Entry).To(Equal("y"))
+			Expect(errs[2].Pos.Line).To(Equal(2))
+			Expect(errs[2].Key).To(Equal("missing3"))
+
+			Expect(errs[3].Entry).To(Equal("z"))
+			Expect(errs[3].Code).To(Equal(ierrors.InvalidArgument))
+
+			Expect(err.Error()).To(HavePrefix("4 replacements failed:\n"))
+			Expect(err.Error()).To(ContainSubstring(
+				`- x: line 1, column 20: not found: missing1 (provider: batch, key: "missing1", error: not found)`,
+			))
+		})
+
+		It("should report the innermost failing tag", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "batch"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, "<replace:<replace:missing>>")
+			var errs Errors
+			Expect(errors.As(err, &errs)).To(BeTrue())
+			Expect(errs).To(HaveLen(1))
+			Expect(errs[0].Key).To(Equal("missing"))
+			Expect(errs[0].Pos.Column).To(Equal(10))
+		})
+	})
+
+	Describe("ReplaceAll (concurrency)", func() {
+		var provider *concurrencyProvider
+		providers.Register("concurrency", func() (providers.ValueProvider, error) {
+			return provider, nil
+		})
+
+		template := func(n int) string {
+			var sb strings.Builder
+			for i := 0; i < n; i++ {
+				fmt.Fprintf(&sb, "<replace:key%d>", i)
+			}
+			return sb.String()
+		}
+
+		BeforeEach(func() {
+			provider = &concurrencyProvider{}
+		})
+
+		It("should limit the number of concurrent fetches", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "concurrency"}, WithMaxConcurrency(4))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, template(40))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(provider.maxActive).To(BeNumerically("<=", 4))
+			Expect(provider.maxActive).To(BeNumerically(">", 1))
+		})
+
+		It("should only lower the limit with annotations", func() {
+			r, err := New(map[string]string{
+				replacerKeyPrefix + "provider":        "concurrency",
+				replacerKeyPrefix + "max_concurrency": "100",
+			}, WithMaxConcurrency(4))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.maxConcurrency).To(Equal(4))
+
+			r, err = New(map[string]string{
+				replacerKeyPrefix + "provider":        "concurrency",
+				replacerKeyPrefix + "max_concurrency": "1",
+			}, WithMaxConcurrency(4))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, template(10))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(provider.maxActive).To(Equal(int32(1)))
+		})
+
+		It("should limit the number of concurrent calls to a provider", func() {
+			r, err := New(map[string]string{
+				replacerKeyPrefix + "provider":                    "concurrency",
+				replacerKeyPrefix + "concurrency.max_concurrency": "2",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, template(20))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(provider.maxActive).To(BeNumerically("<=", 2))
+
+			_, err = New(map[string]string{
+				replacerKeyPrefix + "provider":                    "concurrency",
+				replacerKeyPrefix + "concurrency.max_concurrency": "x",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should wait for the rate limiter", func() {
+			limiter := providers.NewRateLimiter(100, 1)
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"}, WithRateLimiter(limiter))
+			Expect(err).ToNot(HaveOccurred())
+
+			start := time.Now()
+			_, err = r.ReplaceAll(ctx, `<replace:key1> <replace:key2> <replace:key3> <replace:key4>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically(">=", 25*time.Millisecond))
+		})
+	})
+
+	Describe("ReplaceAll (pool)", func() {
+		It("should share providers between replacers", func() {
+			pool := providers.NewPool(0)
+			cfg := map[string]string{
+				replacerKeyPrefix + "provider": "test",
+				replacerKeyPrefix + "test.x":   "y",
+			}
+
+			r1, err := New(cfg, WithPool(pool))
+			Expect(err).ToNot(HaveOccurred())
+			r2, err := New(cfg, WithPool(pool))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r2.providers["test"]).To(BeIdenticalTo(r1.providers["test"]))
+			Expect(pool.Len()).To(Equal(1))
+
+			res, err := r2.ReplaceAll(ctx, `<replace:key1>`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("value1"))
+
+			r1.Close()
+			r2.Close()
+			pool.Close()
+			Expect(pool.Len()).To(Equal(0))
+		})
+	})
+
+	Describe("ReplaceAll (authorizer)", func() {
+		var batch *batchProvider
+		providers.Register("batch-authorized", func() (providers.ValueProvider, error) {
+			return batch, nil
+		})
+		authorize := func(provider string, config map[string]string, key string) error {
+			if key == "c" || key == "key2" {
+				return fmt.Errorf("%s:%s is not allowed", provider, key)
+			} else if config["mount"] != "" {
+				return fmt.Errorf("mount %s is not allowed", config["mount"])
+			}
+			return nil
+		}
+
+		BeforeEach(func() {
+			batch = &batchProvider{values: map[string]string{"a": "1", "b": "c", "c": "3"}}
+		})
+
+		It("should not fetch denied keys", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "batch-authorized"}, WithAuthorizer(authorize))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceMap(ctx, map[string]string{
+				"x": "<replace:a> <replace:c>",
+				"y": "<replace:c ?? default>",
+			})
+			var errs Errors
+			Expect(errors.As(err, &errs)).To(BeTrue())
+			Expect(errs).To(HaveLen(2))
+			Expect(errs[0].Key).To(Equal("c"))
+			Expect(errs[0].Code).To(Equal(ierrors.PermissionDenied))
+			Expect(errs[0].Error()).To(ContainSubstring("batch-authorized:c is not allowed"))
+			Expect(errs[1].Entry).To(Equal("y"))
+			Expect(errs[1].Code).To(Equal(ierrors.PermissionDenied))
+
+			Expect(batch.batchCalls).To(Equal(0))
+			Expect(batch.calls).To(Equal(1))
+		})
+
+		It("should check keys of nested tags", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "batch-authorized"}, WithAuthorizer(authorize))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, "<replace:<replace:b>>")
+			Expect(errors.Is(err, ierrors.ErrPermissionDenied)).To(BeTrue())
+			Expect(batch.calls).To(Equal(1))
+		})
+
+		It("should check the keys of every provider", func() {
+			r, err := New(map[string]string{replacerKeyPrefix + "provider": "test"}, WithAuthorizer(authorize))
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.ReplaceAll(ctx, "<replace:key1>")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("value1"))
+
+			_, err = r.ReplaceAll(ctx, "<replace:key2>")
+			Expect(err).To(MatchError(ContainSubstring("test:key2 is not allowed")))
+		})
+
+		It("should pass the provider config of the resource", func() {
+			r, err := New(map[string]string{
+				replacerKeyPrefix + "provider":                         "batch-authorized",
+				replacerKeyPrefix + "batch-authorized.mount":           "other",
+				replacerKeyPrefix + "batch-authorized.max_concurrency": "2",
+			}, WithAuthorizer(authorize))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.ReplaceAll(ctx, "<replace:a>")
+			Expect(err).To(MatchError(ContainSubstring("mount other is not allowed")))
+			Expect(batch.calls).To(Equal(0))
+		})
+	})
+
 	//	Describe("ReplaceAll (gcp)", func() {
 	//		It("should replace values with the gcp provider", func() {
 	//			r, err := New(map[string]string{
@@ -83,7 +953,7 @@ var _ = Describe("Replacer", func() {
 	//			})
 	//			Expect(err).ToNot(HaveOccurred())
 	//
-	//			res, err := r.ReplaceAll(`
+	//			res, err := r.ReplaceAll(ctx, `
 	//aurthur_secrets.yaml
 	//	<replace:production-aurthur-secrets>
 	//admin_password: <replace:projects/cohere-cd/secrets/production-admin-password>
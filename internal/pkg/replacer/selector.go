@@ -0,0 +1,194 @@
+package replacer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aar10n/replacer/internal/pkg/providers"
+
+	"sigs.k8s.io/yaml"
+)
+
+// selector is a path into a structured (JSON or YAML) value, such as
+// .credentials.password, .hosts[0] or .["key.with.dots"].
+type selector []pathElem
+
+type pathElem struct {
+	field string
+	index int
+	isIdx bool
+}
+
+func (e pathElem) String() string {
+	if e.isIdx {
+		return fmt.Sprintf("[%d]", e.index)
+	} else if isIdentifier(e.field) {
+		return "." + e.field
+	}
+	return fmt.Sprintf("[%q]", e.field)
+}
+
+// parseSelector parses a selector string. Fields are separated by dots, array
+// elements are selected with [n] and fields containing special characters may
+// be quoted with ["..."].
+func parseSelector(s string) (selector, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	var sel selector
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '.':
+			i++
+			if i < len(s) && s[i] == '[' {
+				continue
+			}
+
+			j := i
+			for j < len(s) && s[j] != '.' && s[j] != '[' {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("invalid selector %s: empty field at position %d", s, i)
+			}
+			sel = append(sel, pathElem{field: s[i:j]})
+			i = j
+		case '[':
+			if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\'') {
+				// quoted field, scan to the closing quote
+				quote := s[i+1]
+				j := i + 2
+				for ; j < len(s) && s[j] != quote; j++ {
+					if s[j] == '\\' && quote == '"' {
+						j++
+					}
+				}
+				if j+1 >= len(s) || s[j+1] != ']' {
+					return nil, fmt.Errorf("invalid selector %s: unterminated field at position %d", s, i)
+				}
+
+				field, err := unquoteField(s[i+1 : j+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid selector %s: %v", s, err)
+				}
+				sel = append(sel, pathElem{field: field})
+				i = j + 2
+				continue
+			}
+
+			j := strings.IndexByte(s[i:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("invalid selector %s: missing ]", s)
+			}
+
+			inner := s[i+1 : i+j]
+			index, err := strconv.Atoi(inner)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid selector %s: invalid index %q", s, inner)
+			}
+			sel = append(sel, pathElem{index: index, isIdx: true})
+			i += j + 1
+		default:
+			return nil, fmt.Errorf("invalid selector %s: unexpected %q at position %d", s, s[i], i)
+		}
+	}
+	return sel, nil
+}
+
+// apply parses the value as JSON or YAML and returns the selected element.
+// Strings are returned as-is, other scalars in their JSON form, and objects
+// and arrays as JSON.
+func (sel selector) apply(value string) (string, error) {
+	if len(sel) == 0 {
+		return value, nil
+	}
+
+	b, err := yaml.YAMLToJSON([]byte(value))
+	if err != nil {
+		return "", fmt.Errorf("selector %s: value is not valid json or yaml: %v", sel, err)
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", fmt.Errorf("selector %s: value is not valid json or yaml: %v", sel, err)
+	}
+
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return "", fmt.Errorf("selector %s: value is not an object or array", sel)
+	}
+
+	// the value is structured, so a path that does not match it is a miss
+	// which falls back to the default of the tag
+	for i, elem := range sel {
+		path := sel[:i+1].String()
+		switch node := v.(type) {
+		case map[string]interface{}:
+			if elem.isIdx {
+				return "", fmt.Errorf("selector %s: cannot index object at %s: %w", sel, path, providers.ErrNotFound)
+			}
+			child, ok := node[elem.field]
+			if !ok {
+				return "", fmt.Errorf("selector %s: field %s %w", sel, path, providers.ErrNotFound)
+			}
+			v = child
+		case []interface{}:
+			if !elem.isIdx {
+				return "", fmt.Errorf("selector %s: cannot select field of array at %s: %w", sel, path, providers.ErrNotFound)
+			} else if elem.index >= len(node) {
+				return "", fmt.Errorf("selector %s: index %s out of range: %w", sel, path, providers.ErrNotFound)
+			}
+			v = node[elem.index]
+		default:
+			return "", fmt.Errorf("selector %s: cannot select %s of a scalar value: %w", sel, path, providers.ErrNotFound)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (sel selector) String() string {
+	var sb strings.Builder
+	for _, elem := range sel {
+		sb.WriteString(elem.String())
+	}
+	return sb.String()
+}
+
+func unquoteField(s string) (string, error) {
+	if strings.HasPrefix(s, "'") {
+		return s[1 : len(s)-1], nil
+	}
+	return strconv.Unquote(s)
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -1,27 +1,60 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"os"
+	"time"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
+	"github.com/aar10n/replacer/api/v1alpha1"
+	"github.com/aar10n/replacer/controllers"
+	"github.com/aar10n/replacer/internal/pkg/digest"
+	"github.com/aar10n/replacer/internal/pkg/providers/k8s"
+	"github.com/aar10n/replacer/internal/pkg/replacer"
 	"github.com/aar10n/replacer/webhooks"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
 var (
+	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+}
+
 func main() {
 	var (
 		certDir              string
 		metricsAddr          string
 		probeAddr            string
 		enableLeaderElection bool
+		crossNamespace       string
+		webhookTimeout       time.Duration
+		providerIdleTimeout  time.Duration
+		maxConcurrency       int
+		providerQPS          float64
+		providerBurst        int
+		resourcesConfig      string
+		webhookConfigName    string
+		refreshInterval      time.Duration
+		enforcePolicies      bool
+		hashKeySecret        string
 	)
 
 	flag.StringVar(&certDir, "cert-dir", "/tmp/serving-certs", "The directory containing the server certificate.")
@@ -30,6 +63,34 @@ func main() {
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&crossNamespace, "k8s-cross-namespace", string(k8s.CrossNamespaceDeny),
+		"The policy for reading objects in other namespaces with the k8s provider. "+
+			"One of deny, annotated or allow.")
+	flag.DurationVar(&webhookTimeout, "webhook-timeout", webhooks.DefaultTimeout,
+		"The timeout of the webhook in the API server. It should match timeoutSeconds "+
+			"in the webhook configuration.")
+	flag.DurationVar(&providerIdleTimeout, "provider-idle-timeout", 5*time.Minute,
+		"The time after which unused provider clients and caches are closed.")
+	flag.IntVar(&maxConcurrency, "max-concurrency", replacer.DefaultMaxConcurrency,
+		"The maximum number of values fetched concurrently for a single resource.")
+	flag.Float64Var(&providerQPS, "provider-qps", 50,
+		"The maximum rate of calls to each provider across all resources (0 for no limit).")
+	flag.IntVar(&providerBurst, "provider-burst", 100,
+		"The maximum burst of calls to each provider across all resources.")
+
+	flag.StringVar(&resourcesConfig, "resources-config", "",
+		"A file with the resources other than secrets and configmaps in which tags are replaced.")
+	flag.StringVar(&webhookConfigName, "webhook-config", "",
+		"The name of the mutating webhook configuration. If set, its rules are updated "+
+			"to match the configured resources.")
+	flag.DurationVar(&refreshInterval, "refresh-interval", time.Hour,
+		"The interval at which replaced secrets and configmaps are rendered again to pick up "+
+			"rotated values (0 to only refresh objects with a refresh_interval annotation).")
+	flag.BoolVar(&enforcePolicies, "enforce-policies", true,
+		"Only read the keys allowed by ReplacerPolicies once any policy exists.")
+	flag.StringVar(&hashKeySecret, "hash-key-secret", "replacer-hash-key",
+		"The secret in the namespace of the manager (POD_NAMESPACE) holding the key of the hashes "+
+			"of rendered values. It is created with a random key if it does not exist.")
 
 	opts := zap.Options{
 		Development: true,
@@ -39,7 +100,17 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	// only cache the secrets and configmaps managed by the replacer, so that
+	// the controllers do not hold every object of the cluster in memory
+	managed := labels.SelectorFromSet(labels.Set{webhooks.ManagedLabel: "true"})
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		NewCache: cache.BuilderWithOptions(cache.Options{
+			SelectorsByObject: cache.SelectorsByObject{
+				&corev1.Secret{}:    {Label: managed},
+				&corev1.ConfigMap{}: {Label: managed},
+			},
+		}),
+		Scheme:                 scheme,
 		CertDir:                certDir,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
@@ -52,13 +123,89 @@ func main() {
 		os.Exit(1)
 	}
 
+	// the hash key is stored in the namespace of the manager, which is set
+	// from the downward api in config/manager/manager.yaml
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		setupLog.Error(errors.New("POD_NAMESPACE is not set"),
+			"POD_NAMESPACE must be set to the namespace of the manager to store the hash key")
+		os.Exit(1)
+	}
+	hashKey, err := digest.LoadKey(context.Background(), mgr.GetClient(), mgr.GetAPIReader(),
+		types.NamespacedName{Namespace: namespace, Name: hashKeySecret})
+	if err != nil {
+		setupLog.Error(err, "unable to load the hash key")
+		os.Exit(1)
+	}
+	digest.SetKey(hashKey)
+
+	// the requests of the manager are not rendered again by the webhooks, so
+	// the controllers render each value once under the subject of the policy
+	serviceAccount := os.Getenv("POD_SERVICE_ACCOUNT")
+	if serviceAccount == "" {
+		setupLog.Error(errors.New("POD_SERVICE_ACCOUNT is not set"),
+			"POD_SERVICE_ACCOUNT must be set to the service account of the manager")
+		os.Exit(1)
+	}
+	managerUser := "system:serviceaccount:" + namespace + ":" + serviceAccount
+
+	crossNamespacePolicy, err := k8s.ParseCrossNamespacePolicy(crossNamespace)
+	if err != nil {
+		setupLog.Error(err, "invalid flag value")
+		os.Exit(1)
+	}
+
+	var resources []webhooks.Resource
+	if resourcesConfig != "" {
+		resources, err = webhooks.LoadResources(resourcesConfig)
+		if err != nil {
+			setupLog.Error(err, "invalid flag value")
+			os.Exit(1)
+		}
+	}
+
 	setupLog.Info("registering webhooks")
-	err = webhooks.RegisterWebhooksWithManager(mgr)
+	hook, err := webhooks.RegisterWebhooksWithManager(mgr, webhooks.Options{
+		CrossNamespacePolicy: crossNamespacePolicy,
+		Timeout:              webhookTimeout,
+		ProviderIdleTimeout:  providerIdleTimeout,
+		MaxConcurrency:       maxConcurrency,
+		ProviderQPS:          providerQPS,
+		ProviderBurst:        providerBurst,
+		Resources:            resources,
+		WebhookConfigName:    webhookConfigName,
+		EnforcePolicies:      enforcePolicies,
+		ManagerUser:          managerUser,
+	})
 	if err != nil {
 		setupLog.Error(err, "failed to register webhooks")
 		os.Exit(1)
 	}
 
+	setupLog.Info("registering controllers")
+	for _, obj := range []client.Object{&corev1.Secret{}, &corev1.ConfigMap{}} {
+		r := controllers.NewRotationReconciler(mgr.GetClient(), hook, refreshInterval, obj)
+		if err := r.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "failed to register controllers")
+			os.Exit(1)
+		}
+	}
+
+	err = (&controllers.TemplateReconciler{
+		Client:          mgr.GetClient(),
+		Scheme:          mgr.GetScheme(),
+		Pool:            hook.Pool,
+		RateLimiter:     hook.RateLimiter,
+		MaxConcurrency:  maxConcurrency,
+		Interval:        refreshInterval,
+		Timeout:         webhookTimeout,
+		EnforcePolicies: enforcePolicies,
+	}).SetupWithManager(mgr)
+	if err != nil {
+		setupLog.Error(err, "failed to register controllers")
+		os.Exit(1)
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
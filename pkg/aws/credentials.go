@@ -0,0 +1,156 @@
+package aws
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSTSEndpoint      = "https://sts.amazonaws.com"
+	credentialsExpiryWindow = 5 * time.Minute
+	stsTimeout              = 10 * time.Second
+)
+
+// Credentials holds a set of AWS credentials.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// CredentialsProvider returns credentials used to sign requests.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// StaticCredentials is a CredentialsProvider that always returns the same credentials.
+type StaticCredentials Credentials
+
+func (c StaticCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	return Credentials(c), nil
+}
+
+// DefaultCredentials returns a provider for the credentials found in the environment.
+// Static keys (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY) take precedence over a web
+// identity token (AWS_ROLE_ARN, AWS_WEB_IDENTITY_TOKEN_FILE) as used by IRSA on EKS.
+func DefaultCredentials() (CredentialsProvider, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return StaticCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN != "" && tokenFile != "" {
+		endpoint := defaultSTSEndpoint
+		if region := envRegion(); region != "" {
+			endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+		}
+		return &WebIdentityCredentials{
+			RoleARN:     roleARN,
+			TokenFile:   tokenFile,
+			SessionName: "replacer",
+			Endpoint:    endpoint,
+		}, nil
+	}
+	return nil, errors.New("aws: no credentials found in environment")
+}
+
+// WebIdentityCredentials retrieves temporary credentials by exchanging a web identity
+// token with STS. The credentials are cached until shortly before they expire.
+type WebIdentityCredentials struct {
+	RoleARN     string
+	TokenFile   string
+	SessionName string
+	Endpoint    string
+	// Client is used for requests to STS. If nil, a client with a timeout of
+	// stsTimeout is used.
+	Client *http.Client
+
+	lock  sync.Mutex
+	creds Credentials
+}
+
+func (w *WebIdentityCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.creds.AccessKeyID != "" && time.Until(w.creds.Expires) > credentialsExpiryWindow {
+		return w.creds, nil
+	}
+
+	token, err := os.ReadFile(w.TokenFile)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	params := url.Values{}
+	params.Set("Action", "AssumeRoleWithWebIdentity")
+	params.Set("Version", "2011-06-15")
+	params.Set("RoleArn", w.RoleARN)
+	params.Set("RoleSessionName", w.SessionName)
+	params.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: stsTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Credentials{}, err
+	} else if resp.StatusCode != http.StatusOK {
+		return Credentials{}, fmt.Errorf("aws: sts returned %s: %s", resp.Status, body)
+	}
+
+	var out struct {
+		Credentials struct {
+			AccessKeyId     string
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      time.Time
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &out); err != nil {
+		return Credentials{}, err
+	}
+
+	w.creds = Credentials{
+		AccessKeyID:     out.Credentials.AccessKeyId,
+		SecretAccessKey: out.Credentials.SecretAccessKey,
+		SessionToken:    out.Credentials.SessionToken,
+		Expires:         out.Credentials.Expiration,
+	}
+	return w.creds, nil
+}
+
+func envRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
@@ -0,0 +1,205 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+var regionPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+const (
+	secretsManagerService = "secretsmanager"
+
+	// MaxBatchSize is the maximum number of secrets in a BatchGetSecretValue call.
+	MaxBatchSize = 20
+)
+
+// Config holds the settings for a SecretsManagerClient.
+type Config struct {
+	// Region is the region the client sends requests to.
+	Region string
+	// Endpoint overrides the default regional endpoint (optional).
+	Endpoint string
+	// Credentials is used to sign requests. If nil, the default
+	// credentials from the environment are used.
+	Credentials CredentialsProvider
+}
+
+// Error is returned when the secrets manager service responds with an error.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("aws: %s: %s", e.Code, e.Message)
+}
+
+type SecretsManagerClient struct {
+	client   *http.Client
+	creds    CredentialsProvider
+	region   string
+	endpoint string
+}
+
+// NewSecretsManagerClient creates a new client for the secrets manager service.
+func NewSecretsManagerClient(cfg Config) (*SecretsManagerClient, error) {
+	region := cfg.Region
+	if region == "" {
+		region = envRegion()
+	}
+	if region == "" {
+		return nil, errors.New("aws: missing region")
+	} else if !regionPattern.MatchString(region) {
+		// the region is part of the default endpoint
+		return nil, fmt.Errorf("aws: invalid region: %s", region)
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", region)
+	}
+
+	creds := cfg.Credentials
+	if creds == nil {
+		var err error
+		creds, err = DefaultCredentials()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := &SecretsManagerClient{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		creds:    creds,
+		region:   region,
+		endpoint: endpoint,
+	}
+	return c, nil
+}
+
+// GetSecretValue returns the payload of the given secret. The secret id may be
+// either the name or the full ARN of the secret. If versionStage is empty the
+// AWSCURRENT version is returned. Binary secrets are returned as raw bytes.
+func (s *SecretsManagerClient) GetSecretValue(ctx context.Context, secretID, versionStage string) (string, error) {
+	in := map[string]string{"SecretId": secretID}
+	if versionStage != "" {
+		in["VersionStage"] = versionStage
+	}
+
+	var out struct {
+		SecretString *string
+		SecretBinary []byte
+	}
+	err := s.do(ctx, "GetSecretValue", in, &out)
+	if err != nil {
+		return "", err
+	}
+
+	if out.SecretString != nil {
+		return *out.SecretString, nil
+	}
+	return string(out.SecretBinary), nil
+}
+
+// BatchSecretValue is the result of a BatchGetSecretValue call.
+type BatchSecretValue struct {
+	// Values holds the payloads of the secrets by both name and ARN.
+	Values map[string]string
+	// Errors holds the errors for secrets that could not be read by the
+	// secret id given in the request.
+	Errors map[string]*Error
+}
+
+// BatchGetSecretValue returns the payloads of the current versions of up to
+// MaxBatchSize secrets.
+func (s *SecretsManagerClient) BatchGetSecretValue(ctx context.Context, secretIDs []string) (*BatchSecretValue, error) {
+	if len(secretIDs) > MaxBatchSize {
+		return nil, fmt.Errorf("aws: too many secrets in batch: %d", len(secretIDs))
+	}
+
+	in := map[string]interface{}{"SecretIdList": secretIDs}
+	var out struct {
+		SecretValues []struct {
+			ARN          string
+			Name         string
+			SecretString *string
+			SecretBinary []byte
+		}
+		Errors []struct {
+			SecretId     string
+			ErrorCode    string
+			ErrorMessage string
+		}
+	}
+	err := s.do(ctx, "BatchGetSecretValue", in, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &BatchSecretValue{
+		Values: make(map[string]string, len(out.SecretValues)*2),
+		Errors: make(map[string]*Error, len(out.Errors)),
+	}
+	for _, v := range out.SecretValues {
+		value := string(v.SecretBinary)
+		if v.SecretString != nil {
+			value = *v.SecretString
+		}
+		res.Values[v.Name] = value
+		res.Values[v.ARN] = value
+	}
+	for _, e := range out.Errors {
+		res.Errors[e.SecretId] = &Error{StatusCode: http.StatusOK, Code: e.ErrorCode, Message: e.ErrorMessage}
+	}
+	return res, nil
+}
+
+func (s *SecretsManagerClient) do(ctx context.Context, action string, in interface{}, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", secretsManagerService+"."+action)
+
+	creds, err := s.creds.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	signRequest(req, body, creds, s.region, secretsManagerService, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(respBody, &e)
+		return &Error{StatusCode: resp.StatusCode, Code: e.Type, Message: e.Message}
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,69 @@
+package aws
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAWS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "AWS Suite")
+}
+
+var _ = Describe("signRequest", func() {
+	// requests and signatures from the AWS Signature Version 4 test suite
+	creds := Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	scope := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "
+
+	DescribeTable("should match the test suite",
+		func(method string, url string, contentType string, body string, expected string) {
+			now, err := time.Parse(amzDateFormat, "20150830T123600Z")
+			Expect(err).ToNot(HaveOccurred())
+
+			req, err := http.NewRequest(method, url, strings.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+
+			signRequest(req, []byte(body), creds, "us-east-1", "service", now)
+			Expect(req.Header.Get("X-Amz-Date")).To(Equal("20150830T123600Z"))
+			Expect(req.Header.Get("Authorization")).To(Equal(scope + expected))
+		},
+		Entry("get-vanilla", "GET", "https://example.amazonaws.com/", "", "",
+			"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"),
+		Entry("post-vanilla", "POST", "https://example.amazonaws.com/", "", "",
+			"SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"),
+		Entry("get-vanilla-query-order-key-case", "GET", "https://example.amazonaws.com/?Param2=value2&Param1=value1", "", "",
+			"SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"),
+		Entry("post-x-www-form-urlencoded", "POST", "https://example.amazonaws.com/", "application/x-www-form-urlencoded", "Param1=value1",
+			"SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"),
+	)
+
+	It("should sign the session token", func() {
+		req, err := http.NewRequest("POST", "https://example.amazonaws.com/", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		withToken := creds
+		withToken.SessionToken = "TOKEN"
+		signRequest(req, nil, withToken, "us-east-1", "service", time.Now())
+		Expect(req.Header.Get("X-Amz-Security-Token")).To(Equal("TOKEN"))
+		Expect(req.Header.Get("Authorization")).To(ContainSubstring("SignedHeaders=host;x-amz-date;x-amz-security-token,"))
+	})
+})
+
+var _ = Describe("NewSecretsManagerClient", func() {
+	It("should reject invalid regions", func() {
+		creds := StaticCredentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}
+		_, err := NewSecretsManagerClient(Config{Region: "evil.example.com#", Credentials: creds})
+		Expect(err).To(MatchError(ContainSubstring("invalid region")))
+
+		_, err = NewSecretsManagerClient(Config{Region: "eu-west-1", Credentials: creds})
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
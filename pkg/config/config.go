@@ -53,6 +53,34 @@ func LoadFromMapP(m map[string]string, prefix string, out interface{}) error {
 	return nil
 }
 
+// Values returns the values of the tagged struct fields of in, keyed as they
+// are loaded by LoadFromMap.
+func Values(in interface{}) map[string]string {
+	v := reflect.ValueOf(in)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	m := make(map[string]string)
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag.Get(tagName)
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		key := strings.Split(tag, ",")[0]
+		switch f := v.Field(i); f.Kind() {
+		case reflect.String:
+			m[key] = f.String()
+		case reflect.Int:
+			m[key] = strconv.FormatInt(f.Int(), 10)
+		case reflect.Bool:
+			m[key] = strconv.FormatBool(f.Bool())
+		}
+	}
+	return m
+}
+
 func convertStringAndSetField(s string, f reflect.Value) error {
 	switch f.Kind() {
 	case reflect.String:
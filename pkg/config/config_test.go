@@ -83,6 +83,20 @@ var _ = Describe("Config", func() {
 		Entry(nil, "Baz", "my.prefix/", "true", true),
 	)
 
+	It("should return the values of the tagged fields", func() {
+		type config struct {
+			Foo      string `config:"foo"`
+			Bar      int    `config:"bar,required"`
+			Baz      bool   `config:"baz"`
+			Ignored  string `config:"-"`
+			Untagged string
+		}
+
+		var c config
+		Expect(LoadFromMap(map[string]string{"bar": "+1", "baz": "1", "unknown": "x"}, &c)).To(Succeed())
+		Expect(Values(&c)).To(Equal(map[string]string{"foo": "", "bar": "1", "baz": "true"}))
+	})
+
 	It("should ignore a non-required field that is missing", func() {
 		type config struct {
 			Foo string `config:"foo"`
@@ -13,7 +13,7 @@ type SecretManagerClient struct {
 
 // NewSecretManagerClient opens a connection to the secret manager service.
 func NewSecretManagerClient() (*SecretManagerClient, error) {
-	client, err := secretmanager.NewClient(context.TODO())
+	client, err := secretmanager.NewClient(context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -22,12 +22,13 @@ func NewSecretManagerClient() (*SecretManagerClient, error) {
 
 // GetSecret accesses the given secret and returns its payload.
 // The secret must be in the following format:
-//   projects/<project-id>/secrets/<secret-name>/versions/<version-id>
-func (s *SecretManagerClient) GetSecret(secret string) (string, error) {
+//
+//	projects/<project-id>/secrets/<secret-name>/versions/<version-id>
+func (s *SecretManagerClient) GetSecret(ctx context.Context, secret string) (string, error) {
 	req := &secretmanagerpb.AccessSecretVersionRequest{
 		Name: secret,
 	}
-	resp, err := s.client.AccessSecretVersion(context.TODO(), req)
+	resp, err := s.client.AccessSecretVersion(ctx, req)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,200 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds the settings for a vault client.
+type Config struct {
+	// Address is the address of the vault server (e.g. https://vault:8200).
+	Address string
+	// Namespace is the vault enterprise namespace (optional).
+	Namespace string
+	// CACert is a PEM encoded CA bundle used to verify the server (optional).
+	CACert []byte
+}
+
+// Auth holds the result of a login or token renewal.
+type Auth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// Error is returned when the vault server responds with an error status.
+type Error struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault: request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("vault: request failed with status %d: %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+type Client struct {
+	client    *http.Client
+	address   string
+	namespace string
+
+	token string
+	lock  sync.RWMutex
+}
+
+// NewClient creates a new client for the vault server.
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("vault: missing address")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(cfg.CACert) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(cfg.CACert) {
+			return nil, errors.New("vault: invalid ca bundle")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	c := &Client{
+		client:    &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		address:   strings.TrimSuffix(cfg.Address, "/"),
+		namespace: cfg.Namespace,
+	}
+	return c, nil
+}
+
+// SetToken sets the token used to authenticate requests.
+func (c *Client) SetToken(token string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.token = token
+}
+
+// Login authenticates against the auth method mounted at the given path and
+// sets the resulting client token on the client.
+func (c *Client) Login(ctx context.Context, mount string, body map[string]interface{}) (*Auth, error) {
+	var out struct {
+		Auth *Auth `json:"auth"`
+	}
+	err := c.do(ctx, http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", body, &out)
+	if err != nil {
+		return nil, err
+	} else if out.Auth == nil || out.Auth.ClientToken == "" {
+		return nil, errors.New("vault: login response did not contain a token")
+	}
+
+	c.SetToken(out.Auth.ClientToken)
+	return out.Auth, nil
+}
+
+// RenewSelf renews the lease of the current token.
+func (c *Client) RenewSelf(ctx context.Context) (*Auth, error) {
+	var out struct {
+		Auth *Auth `json:"auth"`
+	}
+	err := c.do(ctx, http.MethodPost, "auth/token/renew-self", map[string]interface{}{}, &out)
+	if err != nil {
+		return nil, err
+	} else if out.Auth == nil {
+		return nil, errors.New("vault: renew response did not contain auth")
+	}
+	return out.Auth, nil
+}
+
+// LookupSelf returns information about the current token.
+func (c *Client) LookupSelf(ctx context.Context) (*Auth, error) {
+	var out struct {
+		Data struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		} `json:"data"`
+	}
+	err := c.do(ctx, http.MethodGet, "auth/token/lookup-self", nil, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &Auth{
+		ClientToken:   c.getToken(),
+		LeaseDuration: out.Data.TTL,
+		Renewable:     out.Data.Renewable,
+	}
+	return auth, nil
+}
+
+// Read reads the given path and returns the data field of the response.
+func (c *Client) Read(ctx context.Context, path string) (map[string]interface{}, error) {
+	var out struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Data, nil
+}
+
+func (c *Client) getToken() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.token
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.address+"/v1/"+strings.TrimPrefix(path, "/"), body)
+	if err != nil {
+		return err
+	}
+	if token := c.getToken(); token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		e := &Error{StatusCode: resp.StatusCode}
+		var errs struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(respBody, &errs) == nil {
+			e.Errors = errs.Errors
+		}
+		return e
+	}
+	return json.Unmarshal(respBody, out)
+}
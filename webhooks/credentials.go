@@ -0,0 +1,110 @@
+package webhooks
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// minSecretLength is the minimum length of a high-entropy string.
+	minSecretLength = 20
+	// minBase64Entropy and minHexEntropy are the minimum entropies in bits
+	// per character of high-entropy strings.
+	minBase64Entropy = 4.2
+	minHexEntropy    = 3.0
+)
+
+// credentialPatterns match well-known formats of credentials.
+var credentialPatterns = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"a private key", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----`)},
+	{"an AWS access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"a GitHub token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`)},
+	{"a GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{"a Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"a Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{"a Stripe key", regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,}`)},
+	{"a JSON web token", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"a URL with a password", regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:[^/\s@]+@`)},
+}
+
+// credentialKind returns what kind of credential a value looks like, or an
+// empty string if it does not look like one. Values which are not valid utf-8
+// are not checked.
+func credentialKind(value string) string {
+	if !utf8.ValidString(value) {
+		return ""
+	}
+
+	for _, p := range credentialPatterns {
+		if p.re.MatchString(value) {
+			return p.name
+		}
+	}
+
+	words := strings.FieldsFunc(value, func(c rune) bool {
+		return !isBase64Char(c)
+	})
+	for _, w := range words {
+		if isHighEntropy(w) {
+			return "a high-entropy string"
+		}
+	}
+	return ""
+}
+
+// isHighEntropy returns true if a word looks like a random hex or base64
+// string. Base64 strings must mix lower and upper case letters and digits,
+// so that identifiers and paths are not matched.
+func isHighEntropy(w string) bool {
+	if len(w) < minSecretLength {
+		return false
+	}
+
+	var lower, upper, digit, hex bool
+	hex = true
+	for _, c := range w {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		}
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			hex = false
+		}
+	}
+
+	if hex {
+		return digit && (lower || upper) && entropy(w) >= minHexEntropy
+	}
+	return lower && upper && digit && entropy(w) >= minBase64Entropy
+}
+
+func isBase64Char(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '+' || c == '/' || c == '=' || c == '-' || c == '_'
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	n := 0
+	for _, c := range s {
+		counts[c]++
+		n++
+	}
+
+	var h float64
+	for _, count := range counts {
+		p := float64(count) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
@@ -0,0 +1,175 @@
+package webhooks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/providers"
+	"github.com/aar10n/replacer/internal/pkg/replacer"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// expandAnnotation is the key of a secret whose fields are expanded into the
+// data of a secret, e.g. gcp:my-project/app-env. Without a provider prefix
+// the default provider is used.
+const expandAnnotation = replacerAnnotationPrefix + "expand"
+
+// expandTag returns the replacement tag for the value of the expand
+// annotation. The key may include selectors and filters as in a tag.
+func expandTag(value string) string {
+	if i := strings.IndexByte(value, ':'); i > 0 && providers.Registered(value[:i]) {
+		return fmt.Sprintf("<replace(%s):%s>", value[:i], value[i+1:])
+	}
+	return "<replace:" + value + ">"
+}
+
+// expandSecret fetches the secret given by the expand annotation and adds
+// each of its fields to the data of secret. Keys which are already set in
+// data or stringData are left as-is. The added keys are recorded in the
+// templates, so that they are expanded again when the secret is rendered. It
+// returns the added keys and their values.
+func expandSecret(ctx context.Context, r *replacer.Replacer, secret *corev1.Secret, templates templateSet) (map[string]string, error) {
+	value := secret.Annotations[expandAnnotation]
+	if value == "" {
+		return nil, nil
+	}
+
+	tag := expandTag(value)
+	payload, err := r.ReplaceEntry(ctx, expandAnnotation, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := parsePayload(payload)
+	if err != nil {
+		return nil, ierrors.New(ierrors.InvalidArgument, fmt.Sprintf("cannot expand %s: %v", value, err))
+	}
+
+	added := make(map[string]string)
+	for k, v := range fields {
+		if _, ok := secret.Data[k]; ok {
+			continue
+		} else if _, ok := secret.StringData[k]; ok {
+			continue
+		}
+
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data[k] = []byte(v)
+		templates.recordExpanded(r, dataPath(k), tag, v)
+		added[k] = v
+	}
+	return added, nil
+}
+
+// parsePayload parses a JSON or YAML object, or a dotenv file, and returns
+// its top-level fields. Strings are returned as-is, other scalars in their
+// JSON form, and objects and arrays as JSON. Every field must be a valid key
+// of a secret.
+func parsePayload(s string) (map[string]string, error) {
+	fields, err := parseObject(s)
+	if err != nil {
+		fields, err = parseDotenv(s)
+		if err != nil {
+			return nil, fmt.Errorf("value is not a json or yaml object, or a dotenv file")
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if errs := validation.IsConfigMapKey(k); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid key %s: %s", k, strings.Join(errs, "; "))
+		}
+	}
+	return fields, nil
+}
+
+// parseObject parses a JSON or YAML object.
+func parseObject(s string) (map[string]string, error) {
+	b, err := yaml.YAMLToJSON([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+
+	var obj map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	} else if obj == nil {
+		return nil, fmt.Errorf("not an object")
+	}
+
+	fields := make(map[string]string, len(obj))
+	for k, v := range obj {
+		switch v := v.(type) {
+		case string:
+			fields[k] = v
+		case nil:
+			fields[k] = ""
+		default:
+			out, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			fields[k] = string(out)
+		}
+	}
+	return fields, nil
+}
+
+// parseDotenv parses KEY=value lines, optionally prefixed with export.
+// Values can be double quoted (supporting the usual escapes) or single
+// quoted (taken literally). Empty lines and comments are skipped.
+func parseDotenv(s string) (map[string]string, error) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=value", n)
+		}
+
+		v = strings.TrimSpace(v)
+		switch {
+		case strings.HasPrefix(v, `"`):
+			uv, err := strconv.Unquote(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid quoted value", n)
+			}
+			v = uv
+		case strings.HasPrefix(v, "'"):
+			if len(v) < 2 || !strings.HasSuffix(v, "'") {
+				return nil, fmt.Errorf("line %d: invalid quoted value", n)
+			}
+			v = v[1 : len(v)-1]
+		}
+		fields[k] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,141 @@
+package webhooks
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aar10n/replacer/internal/pkg/digest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Expand", func() {
+	DescribeTable("parsePayload",
+		func(s string, expected map[string]string) {
+			fields, err := parsePayload(s)
+			if expected == nil {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(Equal(expected))
+			}
+		},
+		Entry("json", `{"a": "x", "b": 12345678901234567890, "c": [1], "d": null}`,
+			map[string]string{"a": "x", "b": "12345678901234567890", "c": "[1]", "d": ""}),
+		Entry("yaml", "a: x\nb:\n  c: true\n", map[string]string{"a": "x", "b": `{"c":true}`}),
+		Entry("dotenv", "# comment\nexport A=x\nB = \"y\\nz\"\nC='$d'\n\n",
+			map[string]string{"A": "x", "B": "y\nz", "C": "$d"}),
+		Entry("invalid key", `{"a/b": "x"}`, nil),
+		Entry("array", `[1, 2]`, nil),
+		Entry("invalid dotenv", "A=x\nB\n", nil),
+	)
+
+	DescribeTable("expandTag",
+		func(value string, expected string) {
+			Expect(expandTag(value)).To(Equal(expected))
+		},
+		Entry(nil, "webhook-test:app-env", "<replace(webhook-test):app-env>"),
+		Entry(nil, "my-project/app-env", "<replace:my-project/app-env>"),
+		Entry(nil, "configmap:env", "<replace:configmap:env>"),
+	)
+
+	Describe("Handle", func() {
+		var w *ReplacerWebhook
+
+		BeforeEach(func() {
+			w = newWebhook()
+		})
+
+		newSecret := func(expand string) *corev1.Secret {
+			return &corev1.Secret{
+				TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: map[string]string{
+					"replacer.agb.dev/provider": "webhook-test",
+					"replacer.agb.dev/expand":   expand,
+				}},
+			}
+		}
+
+		It("should expand the fields of a secret into data keys", func() {
+			req := newRequest(newSecret("webhook-test:app-json"))
+			stored := &corev1.Secret{}
+			applyResponse(req, w.Handle(context.Background(), req), stored)
+			Expect(stored.Data).To(Equal(map[string][]byte{
+				"USER": []byte("admin"),
+				"PORT": []byte("5432"),
+				"TLS":  []byte(`{"enabled":true}`),
+			}))
+		})
+
+		It("should not overwrite keys which are set", func() {
+			secret := newSecret("app-env")
+			secret.Data = map[string][]byte{"USER": []byte("<replace:user>-ro")}
+			secret.StringData = map[string]string{"PASSWORD": "other"}
+
+			req := newRequest(secret)
+			stored := &corev1.Secret{}
+			applyResponse(req, w.Handle(context.Background(), req), stored)
+			Expect(stored.Data).To(Equal(map[string][]byte{"USER": []byte("admin-ro")}))
+			Expect(stored.StringData).To(Equal(map[string]string{"PASSWORD": "other"}))
+		})
+
+		It("should expand the secret again on update", func() {
+			rotatingValues["env"] = "A=1\nB=2\n"
+			secret := newSecret("webhook-rotating:env")
+			secret.Data = map[string][]byte{"C": []byte("3")}
+
+			req := newRequest(secret)
+			stored := &corev1.Secret{}
+			applyResponse(req, w.Handle(context.Background(), req), stored)
+			Expect(stored.Data).To(Equal(map[string][]byte{"A": []byte("1"), "B": []byte("2"), "C": []byte("3")}))
+
+			templates, err := loadTemplates(stored)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(templates).To(HaveKeyWithValue("data.A", &storedTemplate{
+				Template: "<replace(webhook-rotating):env>",
+				Hash:     digest.SumString("1"),
+				Sources:  []string{"webhook-rotating:env"},
+				Expanded: true,
+			}))
+
+			// changed fields are updated and removed fields are removed,
+			// unless their value was changed
+			rotatingValues["env"] = "A=10\nD=4\n"
+			stored.Data["B"] = []byte("manual")
+			req = newRequest(stored)
+			req.Operation = admissionv1.Update
+			updated := &corev1.Secret{}
+			applyResponse(req, w.Handle(context.Background(), req), updated)
+			Expect(updated.Data).To(Equal(map[string][]byte{
+				"A": []byte("10"),
+				"B": []byte("manual"),
+				"C": []byte("3"),
+				"D": []byte("4"),
+			}))
+
+			// keys are removed with the annotation
+			delete(updated.Annotations, expandAnnotation)
+			req = newRequest(updated)
+			req.Operation = admissionv1.Update
+			stored = &corev1.Secret{}
+			applyResponse(req, w.Handle(context.Background(), req), stored)
+			Expect(stored.Data).To(Equal(map[string][]byte{"B": []byte("manual"), "C": []byte("3")}))
+			Expect(stored.Annotations).ToNot(HaveKey(templatesAnnotation))
+		})
+
+		It("should deny secrets which cannot be expanded", func() {
+			resp := w.Handle(context.Background(), newRequest(newSecret("missing")))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusNotFound))
+
+			resp = w.Handle(context.Background(), newRequest(newSecret("keystore")))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusBadRequest))
+			Expect(resp.Result.Message).To(ContainSubstring("cannot expand keystore"))
+		})
+	})
+})
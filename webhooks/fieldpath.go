@@ -0,0 +1,123 @@
+package webhooks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fieldPath is a path to fields of an unstructured object, e.g.
+// spec.containers[*].env[*].value. A * selects all values of an object and
+// [*] selects all elements of an array. Fields containing special characters
+// can be quoted, e.g. metadata.annotations["example.com/key"].
+type fieldPath []pathElem
+
+type pathElem struct {
+	field    string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseFieldPath parses a field path.
+func parseFieldPath(s string) (fieldPath, error) {
+	var path fieldPath
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '[':
+			j := strings.IndexByte(s[i:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("invalid field path %s: missing ]", s)
+			}
+			inner := s[i+1 : i+j]
+			i += j + 1
+
+			switch {
+			case inner == "*":
+				path = append(path, pathElem{isIndex: true, wildcard: true})
+			case strings.HasPrefix(inner, `"`):
+				field, err := strconv.Unquote(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid field path %s: %v", s, err)
+				}
+				path = append(path, pathElem{field: field})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid field path %s: invalid index %q", s, inner)
+				}
+				path = append(path, pathElem{index: index, isIndex: true})
+			}
+		case s[i] == '.' && i > 0:
+			i++
+			fallthrough
+		default:
+			j := strings.IndexAny(s[i:], ".[")
+			if j < 0 {
+				j = len(s) - i
+			}
+			field := s[i : i+j]
+			if field == "" {
+				return nil, fmt.Errorf("invalid field path %s: empty field at position %d", s, i)
+			}
+			path = append(path, pathElem{field: field, wildcard: field == "*"})
+			i += j
+		}
+	}
+
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty field path")
+	}
+	return path, nil
+}
+
+// visit calls fn for each string value at the path in obj, with the concrete
+// path of the value. The value is replaced with the result of fn.
+func (p fieldPath) visit(obj interface{}, fn func(path string, value string) string) {
+	p.walk(obj, "", func(path string, v interface{}) interface{} {
+		if s, ok := v.(string); ok {
+			return fn(path, s)
+		}
+		return v
+	})
+}
+
+func (p fieldPath) walk(v interface{}, prefix string, fn func(path string, v interface{}) interface{}) interface{} {
+	if len(p) == 0 {
+		return fn(prefix, v)
+	}
+
+	elem, rest := p[0], p[1:]
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if elem.isIndex {
+			return v
+		} else if elem.wildcard {
+			for k, child := range v {
+				v[k] = rest.walk(child, joinField(prefix, k), fn)
+			}
+		} else if child, ok := v[elem.field]; ok {
+			v[elem.field] = rest.walk(child, joinField(prefix, elem.field), fn)
+		}
+	case []interface{}:
+		if !elem.isIndex {
+			return v
+		}
+		for i, child := range v {
+			if elem.wildcard || i == elem.index {
+				v[i] = rest.walk(child, fmt.Sprintf("%s[%d]", prefix, i), fn)
+			}
+		}
+	}
+	return v
+}
+
+// joinField appends a field to a concrete path, quoting it if necessary.
+func joinField(prefix string, field string) string {
+	if field == "" || strings.ContainsAny(field, `.[]"*`) {
+		return prefix + "[" + strconv.Quote(field) + "]"
+	} else if prefix == "" {
+		return field
+	}
+	return prefix + "." + field
+}
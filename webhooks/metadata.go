@@ -0,0 +1,92 @@
+package webhooks
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// replaceMetadataAnnotation enables the replacement of tags in the annotations
+// and/or labels of a resource. It is a comma separated list of "annotations"
+// and "labels".
+const replaceMetadataAnnotation = replacerAnnotationPrefix + "replace-metadata"
+
+const (
+	annotationsPath = "metadata.annotations"
+	labelsPath      = "metadata.labels"
+)
+
+// replacesMetadata returns true if the metadata of obj should be replaced.
+func replacesMetadata(obj metav1.Object) bool {
+	return obj.GetAnnotations()[replaceMetadataAnnotation] != ""
+}
+
+// metadataValues returns the annotation and label values of obj which should
+// be replaced according to the replace-metadata annotation, keyed by their
+// path. Annotations with replacer options are never replaced.
+func metadataValues(obj metav1.Object) (map[string]string, error) {
+	option := obj.GetAnnotations()[replaceMetadataAnnotation]
+	if option == "" {
+		return nil, nil
+	}
+
+	values := make(map[string]string)
+	for _, s := range strings.Split(option, ",") {
+		switch strings.TrimSpace(s) {
+		case "annotations":
+			for k, v := range obj.GetAnnotations() {
+				if !strings.HasPrefix(k, replacerAnnotationPrefix) {
+					values[joinField(annotationsPath, k)] = v
+				}
+			}
+		case "labels":
+			for k, v := range obj.GetLabels() {
+				values[joinField(labelsPath, k)] = v
+			}
+		default:
+			return nil, ierrors.New(ierrors.InvalidArgument,
+				fmt.Sprintf("invalid %s value: %s", replaceMetadataAnnotation, option))
+		}
+	}
+	return values, nil
+}
+
+// setMetadataValues sets the replaced annotation and label values of obj.
+// Annotations cannot hold binary values and labels must be valid label values.
+func setMetadataValues(obj metav1.Object, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	annotations := obj.GetAnnotations()
+	for k := range annotations {
+		v, ok := values[joinField(annotationsPath, k)]
+		if !ok {
+			continue
+		} else if !utf8.ValidString(v) {
+			return ierrors.New(ierrors.InvalidArgument,
+				fmt.Sprintf("invalid value for annotation %s: binary values are not supported", k))
+		}
+		annotations[k] = v
+	}
+	obj.SetAnnotations(annotations)
+
+	labels := obj.GetLabels()
+	for k := range labels {
+		v, ok := values[joinField(labelsPath, k)]
+		if !ok {
+			continue
+		} else if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return ierrors.New(ierrors.InvalidArgument,
+				fmt.Sprintf("invalid value for label %s: %s", k, strings.Join(errs, "; ")))
+		}
+		labels[k] = v
+	}
+	obj.SetLabels(labels)
+	return nil
+}
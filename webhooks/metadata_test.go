@@ -0,0 +1,89 @@
+package webhooks
+
+import (
+	"context"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Metadata", func() {
+	var w *ReplacerWebhook
+
+	BeforeEach(func() {
+		w = newWebhook()
+	})
+
+	// newSecret returns a secret with the given annotations and labels, using
+	// the webhook-test provider.
+	newSecret := func(option string, annotations, labels map[string]string) *corev1.Secret {
+		annotations["replacer.agb.dev/provider"] = "webhook-test"
+		if option != "" {
+			annotations["replacer.agb.dev/replace-metadata"] = option
+		}
+		return &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "app",
+				Namespace:   "default",
+				Annotations: annotations,
+				Labels:      labels,
+			},
+		}
+	}
+
+	It("should replace annotations and labels when enabled", func() {
+		secret := newSecret("annotations, labels",
+			map[string]string{"example.com/user": "<replace:user>", "replacer.agb.dev/test.key": "<replace:user>"},
+			map[string]string{"user": "<replace:user>"},
+		)
+		secret.Data = map[string][]byte{"password": []byte("<replace:password>")}
+
+		req := newRequest(secret)
+		stored := &corev1.Secret{}
+		applyResponse(req, w.Handle(context.Background(), req), stored)
+		Expect(stored.Annotations).To(HaveKeyWithValue("example.com/user", "admin"))
+		Expect(stored.Annotations).To(HaveKeyWithValue("replacer.agb.dev/test.key", "<replace:user>"))
+		Expect(stored.Labels).To(Equal(map[string]string{"user": "admin", ManagedLabel: "true"}))
+		Expect(stored.Data).To(Equal(map[string][]byte{"password": []byte("hunter2")}))
+	})
+
+	It("should only replace the enabled metadata", func() {
+		secret := newSecret("labels",
+			map[string]string{"example.com/user": "<replace:user>"},
+			map[string]string{"user": "<replace:user>"},
+		)
+
+		req := newRequest(secret)
+		stored := &corev1.Secret{}
+		applyResponse(req, w.Handle(context.Background(), req), stored)
+		Expect(stored.Annotations).To(HaveKeyWithValue("example.com/user", "<replace:user>"))
+		Expect(stored.Labels).To(Equal(map[string]string{"user": "admin", ManagedLabel: "true"}))
+	})
+
+	It("should not replace metadata by default", func() {
+		secret := newSecret("", map[string]string{}, map[string]string{"user": "<replace:user>"})
+		secret.Data = map[string][]byte{"password": []byte("<replace:password>")}
+
+		req := newRequest(secret)
+		stored := &corev1.Secret{}
+		applyResponse(req, w.Handle(context.Background(), req), stored)
+		Expect(stored.Labels).To(Equal(map[string]string{"user": "<replace:user>", ManagedLabel: "true"}))
+	})
+
+	DescribeTable("should deny invalid metadata",
+		func(option string, labels map[string]string, message string) {
+			secret := newSecret(option, map[string]string{}, labels)
+
+			resp := w.Handle(context.Background(), newRequest(secret))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusBadRequest))
+			Expect(resp.Result.Message).To(ContainSubstring(message))
+		},
+		Entry("invalid option", "spec", map[string]string{}, "invalid replacer.agb.dev/replace-metadata value"),
+		Entry("invalid label value", "labels", map[string]string{"url": "postgres://<replace:user>@db"}, "invalid value for label url"),
+	)
+})
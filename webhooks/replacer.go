@@ -2,27 +2,72 @@ package webhooks
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
+	"unicode/utf8"
 
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/policy"
+	"github.com/aar10n/replacer/internal/pkg/providers"
+	_ "github.com/aar10n/replacer/internal/pkg/providers/aws"
 	_ "github.com/aar10n/replacer/internal/pkg/providers/gcp"
+	_ "github.com/aar10n/replacer/internal/pkg/providers/vault"
 	"github.com/aar10n/replacer/internal/pkg/replacer"
 
-	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
+const (
+	// DefaultTimeout is the default timeout of the API server for calling
+	// the webhook.
+	DefaultTimeout = 10 * time.Second
+	// timeoutMargin is the time reserved for responding to the API server.
+	timeoutMargin = time.Second
+)
+
 type ReplacerWebhook struct {
-	Client  client.Client
-	decoder *admission.Decoder
+	Client client.Client
+	// Pool holds the providers shared between requests (optional).
+	Pool *providers.Pool
+	// RateLimiter limits the calls to providers across requests (optional).
+	RateLimiter *providers.RateLimiter
+	// MaxConcurrency is the maximum number of values fetched concurrently
+	// for a single request.
+	MaxConcurrency int
+	// Timeout is the timeout configured for the webhook in the API server.
+	Timeout time.Duration
+	// Resources are the other kinds of resources in which tags are replaced.
+	Resources []Resource
+	// EnforcePolicies limits the keys read for each request to those allowed
+	// by the ReplacerPolicies, which are read with the client.
+	EnforcePolicies bool
+	// ManagerUser is the user name of the manager. Its requests are allowed
+	// as-is, since the controllers render the values before updating objects.
+	ManagerUser string
+	decoder     *admission.Decoder
 }
 
 func (w *ReplacerWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
 	log := logf.FromContext(ctx)
+	if w.ManagerUser != "" && req.UserInfo.Username == w.ManagerUser {
+		return admission.Allowed("rendered by the manager")
+	}
+
+	budget := w.budget()
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
 
-	var patches []jsonpatch.Operation
+	var obj runtime.Object
+	var changed bool
 	var err error
 	switch req.RequestKind.Kind {
 	case "Secret":
@@ -30,33 +75,87 @@ func (w *ReplacerWebhook) Handle(ctx context.Context, req admission.Request) adm
 		err = w.decoder.Decode(req, secret)
 		if err != nil {
 			return admission.Errored(http.StatusBadRequest, err)
-		} else if len(secret.Data) == 0 {
+		} else if len(secret.Data) == 0 && len(secret.StringData) == 0 &&
+			!replacesMetadata(secret) && secret.Annotations[expandAnnotation] == "" {
 			return admission.Allowed("no data to replace")
 		}
 
 		log.Info("Handle.Secret", "name", secret.Name, "namespace", secret.Namespace)
-		patches, err = w.replaceInSecret(ctx, secret)
+		obj = secret
+		changed, err = w.replaceInSecret(ctx, req, secret)
 	case "ConfigMap":
 		cm := &corev1.ConfigMap{}
 		err = w.decoder.Decode(req, cm)
 		if err != nil {
 			return admission.Errored(http.StatusBadRequest, err)
-		} else if len(cm.Data) == 0 {
+		} else if len(cm.Data) == 0 && len(cm.BinaryData) == 0 && !replacesMetadata(cm) {
 			return admission.Allowed("no data to replace")
 		}
 
 		log.Info("Handle.ConfigMap", "name", cm.Name, "namespace", cm.Namespace)
-		patches, err = w.replaceInConfigMap(ctx, cm)
+		obj = cm
+		changed, err = w.replaceInConfigMap(ctx, req, cm)
 	default:
-		return admission.Allowed("not a secret or configmap")
+		res := w.findResource(req.Kind)
+		if res == nil {
+			return admission.Allowed("not a secret, configmap or configured resource")
+		}
+
+		u := &unstructured.Unstructured{}
+		err = u.UnmarshalJSON(req.Object.Raw)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		log.Info("Handle."+req.Kind.Kind, "name", u.GetName(), "namespace", u.GetNamespace())
+		obj = u
+		changed, err = w.replaceInObject(ctx, req, res, u)
 	}
 
-	if err != nil {
-		return admission.Errored(http.StatusBadRequest, err)
-	} else if patches == nil || len(patches) == 0 {
+	var errs replacer.Errors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			log.Info("replacement failed", "entry", e.Entry, "position", e.Pos.String(),
+				"provider", e.Provider, "key", e.Key, "code", e.Code, "error", e.Err.Error())
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ierrors.ErrTimeout) {
+		return admission.Errored(http.StatusGatewayTimeout, fmt.Errorf("replacement did not finish within %s: %w", budget, err))
+	} else if err != nil {
+		return admission.Errored(errorStatus(err), err)
+	} else if !changed {
 		return admission.Allowed("no changes")
 	}
-	return admission.Patched("replacer changes", patches...)
+
+	// diff the mutated object against the original request
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, raw)
+}
+
+// Render replaces the tags in a secret or configmap as when it is updated, so
+// that values are rendered again from their stored templates. It returns true
+// if any value may have changed.
+func (w *ReplacerWebhook) Render(ctx context.Context, obj client.Object) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.budget())
+	defer cancel()
+
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Update,
+			Namespace: obj.GetNamespace(),
+		},
+	}
+	switch obj := obj.(type) {
+	case *corev1.Secret:
+		return w.replaceInSecret(ctx, req, obj)
+	case *corev1.ConfigMap:
+		return w.replaceInConfigMap(ctx, req, obj)
+	}
+	return false, fmt.Errorf("cannot render %T", obj)
 }
 
 func (w *ReplacerWebhook) InjectDecoder(d *admission.Decoder) error {
@@ -66,53 +165,272 @@ func (w *ReplacerWebhook) InjectDecoder(d *admission.Decoder) error {
 
 //
 
-func (w *ReplacerWebhook) replaceInSecret(ctx context.Context, secret *corev1.Secret) ([]jsonpatch.Operation, error) {
-	r, err := replacer.New(secret.Annotations)
+// errorStatus returns the HTTP status code of the response for a failed
+// replacement. If several tags failed with different errors, permission errors
+// take precedence over backend errors, followed by missing keys.
+func errorStatus(err error) int32 {
+	switch {
+	case errors.Is(err, ierrors.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ierrors.ErrBackendError):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ierrors.ErrNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// budget returns the time available for replacing values, which is the
+// webhook timeout minus a margin to respond before the API server gives up.
+func (w *ReplacerWebhook) budget() time.Duration {
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	if timeout > 2*timeoutMargin {
+		return timeout - timeoutMargin
+	}
+	return timeout / 2
+}
+
+func (w *ReplacerWebhook) newReplacer(ctx context.Context, req admission.Request, annotations map[string]string) (*replacer.Replacer, error) {
+	authorize, err := w.authorizer(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
-	var patches []jsonpatch.Operation
-	for k, oldValueB := range secret.Data {
-		oldValue := string(oldValueB)
-		newValue, err := r.ReplaceAll(oldValue)
-		if err != nil {
-			return nil, err
-		} else if newValue == oldValue {
-			continue
+	return replacer.New(annotations,
+		replacer.WithNamespace(req.Namespace),
+		replacer.WithPool(w.Pool),
+		replacer.WithRateLimiter(w.RateLimiter),
+		replacer.WithMaxConcurrency(w.MaxConcurrency),
+		replacer.WithAuthorizer(authorize),
+	)
+}
+
+// authorizer returns the function checking the keys read for the user of a
+// request, or nil if policies are not enforced.
+func (w *ReplacerWebhook) authorizer(ctx context.Context, req admission.Request) (replacer.AuthorizeFunc, error) {
+	if !w.EnforcePolicies {
+		return nil, nil
+	}
+
+	policies, err := policy.List(ctx, w.Client)
+	if err != nil {
+		return nil, err
+	}
+	return policies.Authorizer(policy.Subject{
+		Namespace: req.Namespace,
+		User:      req.UserInfo.Username,
+		Groups:    req.UserInfo.Groups,
+	}), nil
+}
+
+// replaceInSecret replaces the tags in the data and stringData of a secret,
+// and in its metadata if enabled, then expands the secret given by the expand
+// annotation. Values rendered from stored templates are rendered again, and
+// the templates of all values are stored. It returns true if any value
+// changed.
+func (w *ReplacerWebhook) replaceInSecret(ctx context.Context, req admission.Request, secret *corev1.Secret) (bool, error) {
+	r, err := w.newReplacer(ctx, req, secret.Annotations)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	meta, err := metadataValues(secret)
+	if err != nil {
+		return false, err
+	}
+	templates, err := loadTemplates(secret)
+	if err != nil {
+		return false, err
+	}
+
+	data, stringData := fromBytes(secret.Data), secret.StringData
+	dropped := templates.dropExpanded(data)
+	prevData, prevMeta := copyValues(data), copyValues(meta)
+	templates.restoreData(data)
+	templates.restorePaths(meta)
+
+	res, changed, err := replaceMaps(ctx, r, data, stringData, meta)
+	if err != nil {
+		return false, err
+	}
+	templates.keepData(ctx, r, prevData, res[0])
+	templates.keepPaths(ctx, r, prevMeta, res[2])
+	if err := setMetadataValues(secret, res[2]); err != nil {
+		return false, err
+	}
+	secret.Data = toBytes(res[0])
+	secret.StringData = res[1]
+	secret.Data = moveBinary(secret.StringData, secret.Data)
+
+	// stringData overwrites data when the secret is stored
+	templates = templateSet{}
+	value := func(k string) string {
+		if v, ok := secret.StringData[k]; ok {
+			return v
 		}
+		return string(secret.Data[k])
+	}
+	templates.recordData(ctx, r, data, value)
+	templates.recordData(ctx, r, stringData, value)
+	templates.recordPaths(ctx, r, meta, res[2])
 
-		patches = append(patches, jsonpatch.Operation{
-			Operation: "replace",
-			Path:      "/data/" + k,
-			Value:     newValue,
-		})
+	expanded, err := expandSecret(ctx, r, secret, templates)
+	if err != nil {
+		return false, err
 	}
 
-	return patches, nil
+	saved, err := templates.save(secret)
+	if err != nil {
+		return false, err
+	}
+	return changed || saved || !equalValues(dropped, expanded), nil
 }
 
-func (w *ReplacerWebhook) replaceInConfigMap(ctx context.Context, cm *corev1.ConfigMap) ([]jsonpatch.Operation, error) {
-	r, err := replacer.New(cm.Annotations)
+// replaceInConfigMap replaces the tags in the data and binaryData of a
+// configmap, and in its metadata if enabled. Values rendered from stored
+// templates are rendered again, and the templates of all values are stored.
+// It returns true if any value changed.
+func (w *ReplacerWebhook) replaceInConfigMap(ctx context.Context, req admission.Request, cm *corev1.ConfigMap) (bool, error) {
+	r, err := w.newReplacer(ctx, req, cm.Annotations)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
+	defer r.Close()
 
-	var patches []jsonpatch.Operation
-	for k, v := range cm.Data {
-		newV, err := r.ReplaceAll(v)
-		if err != nil {
-			return nil, err
+	meta, err := metadataValues(cm)
+	if err != nil {
+		return false, err
+	}
+	templates, err := loadTemplates(cm)
+	if err != nil {
+		return false, err
+	}
+
+	data, binaryData := cm.Data, fromBytes(cm.BinaryData)
+	prevData, prevBinary, prevMeta := copyValues(data), copyValues(binaryData), copyValues(meta)
+	templates.restoreData(data)
+	templates.restoreData(binaryData)
+	templates.restorePaths(meta)
+
+	res, changed, err := replaceMaps(ctx, r, data, binaryData, meta)
+	if err != nil {
+		return false, err
+	}
+	templates.keepData(ctx, r, prevData, res[0])
+	templates.keepData(ctx, r, prevBinary, res[1])
+	templates.keepPaths(ctx, r, prevMeta, res[2])
+	if err := setMetadataValues(cm, res[2]); err != nil {
+		return false, err
+	}
+	cm.Data = res[0]
+	cm.BinaryData = toBytes(res[1])
+	cm.BinaryData = moveBinary(cm.Data, cm.BinaryData)
+
+	templates = templateSet{}
+	value := func(k string) string {
+		if v, ok := cm.Data[k]; ok {
+			return v
+		}
+		return string(cm.BinaryData[k])
+	}
+	templates.recordData(ctx, r, data, value)
+	templates.recordData(ctx, r, binaryData, value)
+	templates.recordPaths(ctx, r, meta, res[2])
+	saved, err := templates.save(cm)
+	if err != nil {
+		return false, err
+	}
+	return changed || saved, nil
+}
+
+// equalValues returns true if a and b hold the same keys and values.
+func equalValues(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
 		}
+	}
+	return true
+}
 
-		if newV != v {
-			patches = append(patches, jsonpatch.Operation{
-				Operation: "replace",
-				Path:      "/data/" + k,
-				Value:     newV,
-			})
+// replaceMaps replaces the tags in the values of each map and returns the new
+// maps, along with true if any value changed. The errors of all maps are
+// returned together.
+func replaceMaps(ctx context.Context, r *replacer.Replacer, maps ...map[string]string) ([]map[string]string, bool, error) {
+	var errs replacer.Errors
+	res := make([]map[string]string, len(maps))
+	changed := false
+	for i, m := range maps {
+		if len(m) == 0 {
+			res[i] = m
+			continue
 		}
+
+		newM, err := r.ReplaceMap(ctx, m)
+		var rerrs replacer.Errors
+		if errors.As(err, &rerrs) {
+			errs = append(errs, rerrs...)
+			continue
+		} else if err != nil {
+			return nil, false, err
+		}
+
+		for k, v := range m {
+			if newM[k] != v {
+				changed = true
+			}
+		}
+		res[i] = newM
 	}
 
-	return []jsonpatch.Operation{}, nil
+	if len(errs) > 0 {
+		return nil, false, errs
+	}
+	return res, changed, nil
+}
+
+// moveBinary moves the values of m which are not valid utf-8 to binary, since
+// they would be corrupted when encoding a string field. It returns binary.
+func moveBinary(m map[string]string, binary map[string][]byte) map[string][]byte {
+	for k, v := range m {
+		if utf8.ValidString(v) {
+			continue
+		}
+		if binary == nil {
+			binary = make(map[string][]byte)
+		}
+		binary[k] = []byte(v)
+		delete(m, k)
+	}
+	return binary
+}
+
+func fromBytes(m map[string][]byte) map[string]string {
+	if m == nil {
+		return nil
+	}
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		res[k] = string(v)
+	}
+	return res
+}
+
+func toBytes(m map[string]string) map[string][]byte {
+	if m == nil {
+		return nil
+	}
+	res := make(map[string][]byte, len(m))
+	for k, v := range m {
+		res[k] = []byte(v)
+	}
+	return res
 }
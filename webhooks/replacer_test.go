@@ -1,10 +1,28 @@
 package webhooks
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/aar10n/replacer/api/v1alpha1"
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/providers"
+	"github.com/aar10n/replacer/internal/pkg/replacer"
+
+	jsonpatch "github.com/evanphx/json-patch"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 func TestReplacerWebhook(t *testing.T) {
@@ -12,4 +30,309 @@ func TestReplacerWebhook(t *testing.T) {
 	RunSpecs(t, "ReplacerWebhook Suite")
 }
 
-var _ = Describe("ReplacerWebhook", func() {})
+func init() {
+	providers.Register("webhook-test", func() (providers.ValueProvider, error) {
+		return providers.NewTestProvider(map[string]string{
+			"user":     "admin",
+			"password": "hunter2",
+			"keystore": "/wD+YQ==",
+			"app-env":  "# app\nexport USER=admin\nPASSWORD=\"hunter 2\"\n",
+			"app-json": `{"USER": "admin", "PORT": 5432, "TLS": {"enabled": true}}`,
+		}), nil
+	})
+}
+
+// newRequest returns an admission request to create obj.
+func newRequest(obj runtime.Object) admission.Request {
+	raw, err := json.Marshal(obj)
+	Expect(err).ToNot(HaveOccurred())
+
+	kind := obj.GetObjectKind().GroupVersionKind()
+	return admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation:   admissionv1.Create,
+			Namespace:   "default",
+			RequestKind: &metav1.GroupVersionKind{Version: kind.Version, Kind: kind.Kind},
+			Object:      runtime.RawExtension{Raw: raw},
+		},
+	}
+}
+
+// applyResponse applies the patches of a response to the object of the
+// request and decodes the result into obj, as the API server would store it.
+func applyResponse(req admission.Request, resp admission.Response, obj runtime.Object) {
+	Expect(resp.Allowed).To(BeTrue(), "%v", resp.Result)
+
+	raw := req.Object.Raw
+	if len(resp.Patches) > 0 {
+		b, err := json.Marshal(resp.Patches)
+		Expect(err).ToNot(HaveOccurred())
+		patch, err := jsonpatch.DecodePatch(b)
+		Expect(err).ToNot(HaveOccurred())
+		raw, err = patch.Apply(raw)
+		Expect(err).ToNot(HaveOccurred())
+	}
+	Expect(json.Unmarshal(raw, obj)).To(Succeed())
+}
+
+func newWebhook() *ReplacerWebhook {
+	decoder, err := admission.NewDecoder(scheme.Scheme)
+	Expect(err).ToNot(HaveOccurred())
+
+	w := &ReplacerWebhook{MaxConcurrency: replacer.DefaultMaxConcurrency}
+	Expect(w.InjectDecoder(decoder)).To(Succeed())
+	return w
+}
+
+var _ = Describe("ReplacerWebhook", func() {
+	DescribeTable("budget",
+		func(timeout time.Duration, expected time.Duration) {
+			w := &ReplacerWebhook{Timeout: timeout}
+			Expect(w.budget()).To(Equal(expected))
+		},
+		Entry("default timeout", time.Duration(0), 9*time.Second),
+		Entry("long timeout", 30*time.Second, 29*time.Second),
+		Entry("short timeout", time.Second, 500*time.Millisecond),
+	)
+
+	DescribeTable("errorStatus",
+		func(err error, expected int) {
+			Expect(errorStatus(err)).To(BeEquivalentTo(expected))
+		},
+		Entry("not found", providers.NotFound(errors.New("x")), http.StatusNotFound),
+		Entry("permission denied", ierrors.New(ierrors.PermissionDenied, "x"), http.StatusForbidden),
+		Entry("backend error", ierrors.Wrap(ierrors.BackendError, errors.New("x")), http.StatusServiceUnavailable),
+		Entry("other error", errors.New("x"), http.StatusBadRequest),
+		Entry("mixed errors", replacer.Errors{
+			{Code: ierrors.NotFound, Err: providers.ErrNotFound},
+			{Code: ierrors.PermissionDenied, Err: errors.New("x")},
+		}, http.StatusForbidden),
+	)
+
+	Describe("Handle", func() {
+		var w *ReplacerWebhook
+		annotations := map[string]string{"replacer.agb.dev/provider": "webhook-test"}
+
+		BeforeEach(func() {
+			w = newWebhook()
+		})
+
+		It("should replace secret data and stringData", func() {
+			secret := &corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations},
+				Data: map[string][]byte{
+					"password":    []byte("<replace:password>"),
+					"config.json": []byte(`{"user":"<replace:user>"}`),
+					"plain":       []byte("unchanged"),
+					"binary":      {0xff, 0x00, 0xfe},
+				},
+				StringData: map[string]string{
+					"url": "postgres://<replace:user>:<replace:password>@db",
+				},
+			}
+
+			req := newRequest(secret)
+			resp := w.Handle(context.Background(), req)
+
+			stored := &corev1.Secret{}
+			applyResponse(req, resp, stored)
+			Expect(stored.Data).To(Equal(map[string][]byte{
+				"password":    []byte("hunter2"),
+				"config.json": []byte(`{"user":"admin"}`),
+				"plain":       []byte("unchanged"),
+				"binary":      {0xff, 0x00, 0xfe},
+			}))
+			Expect(stored.StringData).To(Equal(map[string]string{
+				"url": "postgres://admin:hunter2@db",
+			}))
+			Expect(stored.Annotations).To(HaveKeyWithValue("replacer.agb.dev/provider", "webhook-test"))
+			Expect(stored.Annotations).To(HaveKey("replacer.agb.dev/templates"))
+		})
+
+		It("should allow requests of the manager as-is", func() {
+			w.ManagerUser = "system:serviceaccount:replacer-system:replacer-controller-manager"
+			secret := &corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations},
+				StringData: map[string]string{"password": "<replace:password>"},
+			}
+
+			req := newRequest(secret)
+			req.UserInfo.Username = w.ManagerUser
+			resp := w.Handle(context.Background(), req)
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(BeEmpty())
+
+			req.UserInfo.Username = "alice"
+			stored := &corev1.Secret{}
+			applyResponse(req, w.Handle(context.Background(), req), stored)
+			Expect(stored.StringData).To(Equal(map[string]string{"password": "hunter2"}))
+			Expect(stored.Labels).To(HaveKeyWithValue(ManagedLabel, "true"))
+		})
+
+		It("should replace configmap data and binaryData", func() {
+			cm := &corev1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations},
+				Data: map[string]string{
+					"user":  "<replace:user>",
+					"plain": "unchanged",
+				},
+				BinaryData: map[string][]byte{
+					"password": []byte("<replace:password>"),
+				},
+			}
+
+			req := newRequest(cm)
+			resp := w.Handle(context.Background(), req)
+			Expect(resp.Patches).ToNot(BeEmpty())
+
+			stored := &corev1.ConfigMap{}
+			applyResponse(req, resp, stored)
+			Expect(stored.Data).To(Equal(map[string]string{"user": "admin", "plain": "unchanged"}))
+			Expect(stored.BinaryData).To(Equal(map[string][]byte{"password": []byte("hunter2")}))
+		})
+
+		It("should store binary values in binaryData", func() {
+			cm := &corev1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations},
+				Data: map[string]string{
+					"keystore.p12": "<replace:keystore | b64dec>",
+					"user":         "<replace:user>",
+				},
+			}
+
+			req := newRequest(cm)
+			stored := &corev1.ConfigMap{}
+			applyResponse(req, w.Handle(context.Background(), req), stored)
+			Expect(stored.Data).To(Equal(map[string]string{"user": "admin"}))
+			Expect(stored.BinaryData).To(Equal(map[string][]byte{
+				"keystore.p12": {0xff, 0x00, 0xfe, 'a'},
+			}))
+		})
+
+		It("should store binary stringData values in data", func() {
+			secret := &corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations},
+				StringData: map[string]string{"keystore.p12": "<replace:keystore | b64dec>"},
+			}
+
+			req := newRequest(secret)
+			stored := &corev1.Secret{}
+			applyResponse(req, w.Handle(context.Background(), req), stored)
+			Expect(stored.StringData).To(BeEmpty())
+			Expect(stored.Data).To(Equal(map[string][]byte{
+				"keystore.p12": {0xff, 0x00, 0xfe, 'a'},
+			}))
+		})
+
+		It("should not patch objects without tags", func() {
+			cm := &corev1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations},
+				Data:       map[string]string{"plain": "unchanged"},
+			}
+
+			resp := w.Handle(context.Background(), newRequest(cm))
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(BeEmpty())
+		})
+
+		It("should deny objects with missing keys", func() {
+			secret := &corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations},
+				Data:       map[string][]byte{"a": []byte("<replace:missing1>")},
+				StringData: map[string]string{"b": "<replace:missing2>"},
+			}
+
+			resp := w.Handle(context.Background(), newRequest(secret))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusNotFound))
+			Expect(resp.Result.Message).To(ContainSubstring("2 replacements failed"))
+		})
+	})
+
+	Describe("Handle (policies)", func() {
+		var w *ReplacerWebhook
+		annotations := map[string]string{"replacer.agb.dev/provider": "webhook-test"}
+
+		BeforeEach(func() {
+			s := runtime.NewScheme()
+			Expect(scheme.AddToScheme(s)).To(Succeed())
+			Expect(v1alpha1.AddToScheme(s)).To(Succeed())
+
+			w = newWebhook()
+			w.EnforcePolicies = true
+			w.Client = fake.NewClientBuilder().WithScheme(s).WithObjects(&v1alpha1.ReplacerPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "deployers"},
+				Spec: v1alpha1.ReplacerPolicySpec{
+					Subjects: []v1alpha1.PolicySubject{{Kind: v1alpha1.ServiceAccountKind, Name: "deployer"}},
+					Rules:    []v1alpha1.PolicyRule{{Providers: []string{"webhook-test"}, Keys: []string{"user"}}},
+				},
+			}).Build()
+		})
+
+		newSecret := func(key string) (*corev1.Secret, admission.Request) {
+			secret := &corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations},
+				StringData: map[string]string{"value": "<replace:" + key + ">"},
+			}
+			req := newRequest(secret)
+			req.UserInfo.Username = "system:serviceaccount:default:deployer"
+			return secret, req
+		}
+
+		It("should replace keys allowed by a policy", func() {
+			_, req := newSecret("user")
+			stored := &corev1.Secret{}
+			applyResponse(req, w.Handle(context.Background(), req), stored)
+			Expect(stored.StringData).To(HaveKeyWithValue("value", "admin"))
+		})
+
+		It("should deny keys not allowed by any policy", func() {
+			_, req := newSecret("password")
+			resp := w.Handle(context.Background(), req)
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusForbidden))
+			Expect(resp.Result.Message).To(ContainSubstring(
+				"key password of provider webhook-test is not allowed for user " +
+					"system:serviceaccount:default:deployer in namespace default by ReplacerPolicy deployers",
+			))
+		})
+
+		It("should deny provider options not allowed by a policy", func() {
+			secret, _ := newSecret("user")
+			secret.Annotations = map[string]string{
+				"replacer.agb.dev/provider":           "webhook-test",
+				"replacer.agb.dev/webhook-test.mount": "other",
+			}
+			req := newRequest(secret)
+			req.UserInfo.Username = "system:serviceaccount:default:deployer"
+
+			resp := w.Handle(context.Background(), req)
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusForbidden))
+			Expect(resp.Result.Message).To(ContainSubstring("key user of provider webhook-test with options mount=other is not allowed"))
+		})
+
+		It("should deny users to which no policy applies", func() {
+			_, req := newSecret("user")
+			req.UserInfo.Username = "bob"
+			resp := w.Handle(context.Background(), req)
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusForbidden))
+			Expect(resp.Result.Message).To(ContainSubstring("no ReplacerPolicy applies to user bob in namespace default"))
+		})
+
+		It("should only apply policies without subjects when rendering", func() {
+			secret, _ := newSecret("user")
+			_, err := w.Render(context.Background(), secret)
+			Expect(errors.Is(err, ierrors.ErrPermissionDenied)).To(BeTrue())
+		})
+	})
+})
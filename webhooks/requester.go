@@ -0,0 +1,113 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/aar10n/replacer/api/v1alpha1"
+	"github.com/aar10n/replacer/internal/pkg/digest"
+	"github.com/aar10n/replacer/internal/pkg/policy"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// requesterAnnotation holds the user who last created or updated a
+// ReplacerTemplate, so that it is rendered with the policies of that user.
+const requesterAnnotation = replacerAnnotationPrefix + "requester"
+
+// requester is the user who last created or updated a ReplacerTemplate.
+type requester struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+	// Signature is the keyed hash of the user and the template, so that the
+	// requester cannot be forged or copied to other templates.
+	Signature string `json:"signature"`
+}
+
+// sign returns the signature of the requester of a template.
+func (r *requester) sign(tmpl *v1alpha1.ReplacerTemplate) string {
+	annotations := make(map[string]string, len(tmpl.Annotations))
+	for k, v := range tmpl.Annotations {
+		if k != requesterAnnotation {
+			annotations[k] = v
+		}
+	}
+
+	b, _ := json.Marshal(struct {
+		Namespace   string                        `json:"namespace"`
+		Name        string                        `json:"name"`
+		User        string                        `json:"user"`
+		Groups      []string                      `json:"groups"`
+		Annotations map[string]string             `json:"annotations"`
+		Spec        v1alpha1.ReplacerTemplateSpec `json:"spec"`
+	}{tmpl.Namespace, tmpl.Name, r.User, r.Groups, annotations, tmpl.Spec})
+	return digest.Sum(b)
+}
+
+// TemplateSubject returns the policy subject of a template, i.e. the user who
+// last created or updated it. Templates without a valid requester (e.g. ones
+// changed since it was set) render without a user, so that only policies
+// without subjects apply to them.
+func TemplateSubject(tmpl *v1alpha1.ReplacerTemplate) policy.Subject {
+	subject := policy.Subject{Namespace: tmpl.Namespace}
+
+	r := &requester{}
+	if err := json.Unmarshal([]byte(tmpl.Annotations[requesterAnnotation]), r); err != nil || r.User == "" {
+		return subject
+	} else if subtle.ConstantTimeCompare([]byte(r.Signature), []byte(r.sign(tmpl))) != 1 {
+		return subject
+	}
+	subject.User = r.User
+	subject.Groups = r.Groups
+	return subject
+}
+
+// SetRequester sets the user who created or updated a template.
+func SetRequester(tmpl *v1alpha1.ReplacerTemplate, user string, groups []string) error {
+	r := &requester{User: user, Groups: groups}
+	r.Signature = r.sign(tmpl)
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if tmpl.Annotations == nil {
+		tmpl.Annotations = make(map[string]string)
+	}
+	tmpl.Annotations[requesterAnnotation] = string(b)
+	return nil
+}
+
+// TemplateWebhook sets the requester of ReplacerTemplates when they are
+// created or updated.
+type TemplateWebhook struct {
+	decoder *admission.Decoder
+}
+
+func (w *TemplateWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	log := logf.FromContext(ctx)
+
+	tmpl := &v1alpha1.ReplacerTemplate{}
+	if err := w.decoder.Decode(req, tmpl); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	log.Info("Handle.ReplacerTemplate", "name", tmpl.Name, "namespace", tmpl.Namespace)
+
+	if err := SetRequester(tmpl, req.UserInfo.Username, req.UserInfo.Groups); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	raw, err := json.Marshal(tmpl)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, raw)
+}
+
+func (w *TemplateWebhook) InjectDecoder(d *admission.Decoder) error {
+	w.decoder = d
+	return nil
+}
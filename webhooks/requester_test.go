@@ -0,0 +1,90 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aar10n/replacer/api/v1alpha1"
+	"github.com/aar10n/replacer/internal/pkg/policy"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("TemplateWebhook", func() {
+	var w *TemplateWebhook
+
+	BeforeEach(func() {
+		s := runtime.NewScheme()
+		Expect(v1alpha1.AddToScheme(s)).To(Succeed())
+		decoder, err := admission.NewDecoder(s)
+		Expect(err).ToNot(HaveOccurred())
+
+		w = &TemplateWebhook{}
+		Expect(w.InjectDecoder(decoder)).To(Succeed())
+	})
+
+	// create sends a request of alice to create tmpl and returns the stored
+	// template.
+	create := func(tmpl *v1alpha1.ReplacerTemplate) *v1alpha1.ReplacerTemplate {
+		req := newRequest(tmpl)
+		req.UserInfo.Username = "alice"
+		req.UserInfo.Groups = []string{"dev"}
+		stored := &v1alpha1.ReplacerTemplate{}
+		applyResponse(req, w.Handle(context.Background(), req), stored)
+		return stored
+	}
+
+	newTemplate := func() *v1alpha1.ReplacerTemplate {
+		return &v1alpha1.ReplacerTemplate{
+			TypeMeta: metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "ReplacerTemplate"},
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: map[string]string{
+				"replacer.agb.dev/provider": "webhook-test",
+			}},
+			Spec: v1alpha1.ReplacerTemplateSpec{
+				Target: v1alpha1.TemplateTarget{Kind: "Secret"},
+				Data:   map[string]string{"password": "<replace:password>"},
+			},
+		}
+	}
+
+	It("should render templates with the subject of the requester", func() {
+		tmpl := create(newTemplate())
+		Expect(TemplateSubject(tmpl)).To(Equal(policy.Subject{
+			Namespace: "default",
+			User:      "alice",
+			Groups:    []string{"dev"},
+		}))
+	})
+
+	It("should replace requesters set by users", func() {
+		tmpl := newTemplate()
+		tmpl.Annotations[requesterAnnotation] = `{"user":"admin"}`
+		Expect(TemplateSubject(create(tmpl)).User).To(Equal("alice"))
+	})
+
+	It("should ignore requesters of changed or copied templates", func() {
+		tmpl := create(newTemplate())
+		Expect(TemplateSubject(tmpl).User).To(Equal("alice"))
+
+		changed := tmpl.DeepCopy()
+		changed.Spec.Data["password"] = "<replace:user>"
+		Expect(TemplateSubject(changed)).To(Equal(policy.Subject{Namespace: "default"}))
+
+		copied := tmpl.DeepCopy()
+		copied.Name = "other"
+		Expect(TemplateSubject(copied)).To(Equal(policy.Subject{Namespace: "default"}))
+
+		forged := tmpl.DeepCopy()
+		r := &requester{}
+		Expect(json.Unmarshal([]byte(forged.Annotations[requesterAnnotation]), r)).To(Succeed())
+		r.User = "admin"
+		b, err := json.Marshal(r)
+		Expect(err).ToNot(HaveOccurred())
+		forged.Annotations[requesterAnnotation] = string(b)
+		Expect(TemplateSubject(forged)).To(Equal(policy.Subject{Namespace: "default"}))
+	})
+})
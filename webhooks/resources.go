@@ -0,0 +1,170 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+const replacerAnnotationPrefix = "replacer.agb.dev/"
+
+// Resource configures the replacement of tags in a kind of resource other
+// than secrets and configmaps.
+type Resource struct {
+	// Group, Version and Kind identify the resource. If Version is empty, all
+	// versions of the resource are matched.
+	Group   string `json:"group"`
+	Version string `json:"version,omitempty"`
+	Kind    string `json:"kind"`
+	// Resource is the plural name of the resource used in the webhook rules.
+	Resource string `json:"resource"`
+	// Paths are the field paths of the values to replace, e.g.
+	// spec.containers[*].env[*].value.
+	Paths []string `json:"paths"`
+
+	paths []fieldPath
+}
+
+// ResourceConfig is the format of the resources config file.
+type ResourceConfig struct {
+	Resources []Resource `json:"resources"`
+}
+
+// LoadResources loads the resources from a config file.
+func LoadResources(path string) ([]Resource, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &ResourceConfig{}
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return nil, fmt.Errorf("invalid resources config %s: %w", path, err)
+	}
+
+	for i := range cfg.Resources {
+		if err := cfg.Resources[i].compile(); err != nil {
+			return nil, fmt.Errorf("invalid resources config %s: %w", path, err)
+		}
+	}
+	return cfg.Resources, nil
+}
+
+// compile validates the resource and parses its paths.
+func (r *Resource) compile() error {
+	if r.Kind == "" || r.Resource == "" {
+		return fmt.Errorf("resource must have a kind and resource name")
+	} else if r.Group == "" && (r.Kind == "Secret" || r.Kind == "ConfigMap") {
+		return fmt.Errorf("resource %s is always replaced", r.Kind)
+	} else if len(r.Paths) == 0 {
+		return fmt.Errorf("resource %s has no paths", r.Kind)
+	}
+
+	r.paths = nil
+	for _, s := range r.Paths {
+		path, err := parseFieldPath(s)
+		if err != nil {
+			return fmt.Errorf("resource %s: %w", r.Kind, err)
+		}
+		r.paths = append(r.paths, path)
+	}
+	return nil
+}
+
+// matches returns true if the resource matches the kind of an object.
+func (r *Resource) matches(kind metav1.GroupVersionKind) bool {
+	return r.Group == kind.Group && r.Kind == kind.Kind && (r.Version == "" || r.Version == kind.Version)
+}
+
+// findResource returns the configured resource for the kind of an object.
+func (w *ReplacerWebhook) findResource(kind metav1.GroupVersionKind) *Resource {
+	for i := range w.Resources {
+		if w.Resources[i].matches(kind) {
+			return &w.Resources[i]
+		}
+	}
+	return nil
+}
+
+// replaceInObject replaces the tags in the values at the paths of a resource,
+// and in its metadata if enabled. Annotations with replacer options are never
+// replaced. Values rendered from stored templates are rendered again, and the
+// templates of all values are stored. It returns true if any value changed.
+func (w *ReplacerWebhook) replaceInObject(ctx context.Context, req admission.Request, res *Resource, obj *unstructured.Unstructured) (bool, error) {
+	r, err := w.newReplacer(ctx, req, obj.GetAnnotations())
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	excluded := make(map[string]bool)
+	for k := range obj.GetAnnotations() {
+		if strings.HasPrefix(k, replacerAnnotationPrefix) {
+			excluded[joinField(annotationsPath, k)] = true
+		}
+	}
+
+	meta, err := metadataValues(obj)
+	if err != nil {
+		return false, err
+	}
+	templates, err := loadTemplates(obj)
+	if err != nil {
+		return false, err
+	}
+
+	values := make(map[string]string)
+	for k, v := range meta {
+		values[k] = v
+	}
+	for _, path := range res.paths {
+		path.visit(obj.Object, func(path string, value string) string {
+			if !excluded[path] {
+				values[path] = value
+			}
+			return value
+		})
+	}
+	prev := copyValues(values)
+	templates.restorePaths(values)
+
+	newValues, err := r.ReplaceMap(ctx, values)
+	if err != nil {
+		return false, err
+	}
+	templates.keepPaths(ctx, r, prev, newValues)
+
+	changed := false
+	for _, path := range res.paths {
+		path.visit(obj.Object, func(path string, value string) string {
+			if newValue, ok := newValues[path]; ok && newValue != value {
+				changed = true
+				return newValue
+			}
+			return value
+		})
+	}
+
+	for k, v := range meta {
+		if newValues[k] != v {
+			changed = true
+		}
+	}
+	if err := setMetadataValues(obj, newValues); err != nil {
+		return false, err
+	}
+
+	templates = templateSet{}
+	templates.recordPaths(ctx, r, values, newValues)
+	saved, err := templates.save(obj)
+	if err != nil {
+		return false, err
+	}
+	return changed || saved, nil
+}
@@ -0,0 +1,222 @@
+package webhooks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const resourcesConfig = `
+resources:
+  - group: apps
+    version: v1
+    kind: Deployment
+    resource: deployments
+    paths:
+      - spec.template.spec.containers[*].env[*].value
+  - group: networking.k8s.io
+    kind: Ingress
+    resource: ingresses
+    paths:
+      - metadata.annotations.*
+`
+
+// loadResources loads the resources of a config file with the given contents.
+func loadResources(config string) ([]Resource, error) {
+	path := filepath.Join(GinkgoT().TempDir(), "resources.yaml")
+	Expect(os.WriteFile(path, []byte(config), 0600)).To(Succeed())
+	return LoadResources(path)
+}
+
+var _ = Describe("Resources", func() {
+	DescribeTable("parseFieldPath",
+		func(s string, valid bool) {
+			_, err := parseFieldPath(s)
+			if valid {
+				Expect(err).ToNot(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry(nil, "spec.containers[*].env[*].value", true),
+		Entry(nil, `metadata.annotations["example.com/key"]`, true),
+		Entry(nil, "metadata.annotations.*", true),
+		Entry(nil, "spec.items[2]", true),
+		Entry(nil, "", false),
+		Entry(nil, ".spec", false),
+		Entry(nil, "spec..containers", false),
+		Entry(nil, "spec.items[x]", false),
+		Entry(nil, "spec.items[0", false),
+	)
+
+	It("should visit the values at a path", func() {
+		obj := map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{"example.com/key": "a", "plain": "b"},
+			},
+			"items": []interface{}{"c", 1, "d"},
+		}
+
+		visited := make(map[string]string)
+		for _, s := range []string{"metadata.annotations.*", "items[*]"} {
+			path, err := parseFieldPath(s)
+			Expect(err).ToNot(HaveOccurred())
+			path.visit(obj, func(path string, value string) string {
+				visited[path] = value
+				return value + "!"
+			})
+		}
+
+		Expect(visited).To(Equal(map[string]string{
+			`metadata.annotations["example.com/key"]`: "a",
+			"metadata.annotations.plain":              "b",
+			"items[0]":                                "c",
+			"items[2]":                                "d",
+		}))
+		Expect(obj["items"]).To(Equal([]interface{}{"c!", 1, "d!"}))
+	})
+
+	It("should load and validate the resources config", func() {
+		resources, err := loadResources(resourcesConfig)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resources).To(HaveLen(2))
+		Expect(resources[0].matches(metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})).To(BeTrue())
+		Expect(resources[1].matches(metav1.GroupVersionKind{Group: "networking.k8s.io", Version: "v1beta1", Kind: "Ingress"})).To(BeTrue())
+
+		_, err = loadResources("resources:\n  - kind: Pod\n    resource: pods\n")
+		Expect(err).To(MatchError(ContainSubstring("has no paths")))
+		_, err = loadResources("resources:\n  - kind: Pod\n    resource: pods\n    paths: [\"spec..x\"]\n")
+		Expect(err).To(MatchError(ContainSubstring("empty field")))
+		_, err = loadResources("resources:\n  - kind: Secret\n    resource: secrets\n    paths: [\"data.*\"]\n")
+		Expect(err).To(MatchError(ContainSubstring("always replaced")))
+	})
+
+	Describe("Handle", func() {
+		var w *ReplacerWebhook
+		annotations := map[string]string{"replacer.agb.dev/provider": "webhook-test"}
+
+		BeforeEach(func() {
+			resources, err := loadResources(resourcesConfig)
+			Expect(err).ToNot(HaveOccurred())
+
+			w = newWebhook()
+			w.Resources = resources
+		})
+
+		It("should replace values at the configured paths", func() {
+			deployment := &appsv1.Deployment{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations},
+				Spec: appsv1.DeploymentSpec{
+					Replicas: func(n int32) *int32 { return &n }(3),
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{
+								Name:    "app",
+								Command: []string{"<replace:user>"},
+								Env: []corev1.EnvVar{
+									{Name: "USER", Value: "<replace:user>"},
+									{Name: "PLAIN", Value: "plain"},
+								},
+							}},
+						},
+					},
+				},
+			}
+
+			req := newRequest(deployment)
+			req.Kind = metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+			stored := &appsv1.Deployment{}
+			applyResponse(req, w.Handle(context.Background(), req), stored)
+
+			container := stored.Spec.Template.Spec.Containers[0]
+			Expect(container.Env).To(Equal([]corev1.EnvVar{
+				{Name: "USER", Value: "admin"},
+				{Name: "PLAIN", Value: "plain"},
+			}))
+			Expect(container.Command).To(Equal([]string{"<replace:user>"}))
+			Expect(*stored.Spec.Replicas).To(Equal(int32(3)))
+		})
+
+		It("should not replace replacer annotations", func() {
+			ingress := &networkingv1.Ingress{
+				TypeMeta: metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "Ingress"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: map[string]string{
+					"replacer.agb.dev/provider":       "webhook-test",
+					"replacer.agb.dev/test.key":       "<replace:user>",
+					"example.com/basic-auth-password": "<replace:password>",
+				}},
+			}
+
+			req := newRequest(ingress)
+			req.Kind = metav1.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+			stored := &networkingv1.Ingress{}
+			applyResponse(req, w.Handle(context.Background(), req), stored)
+			delete(stored.Annotations, "replacer.agb.dev/templates")
+			Expect(stored.Annotations).To(Equal(map[string]string{
+				"replacer.agb.dev/provider":       "webhook-test",
+				"replacer.agb.dev/test.key":       "<replace:user>",
+				"example.com/basic-auth-password": "hunter2",
+			}))
+		})
+
+		It("should allow resources which are not configured", func() {
+			pod := &corev1.Pod{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations},
+			}
+
+			req := newRequest(pod)
+			req.Kind = metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}
+			resp := w.Handle(context.Background(), req)
+			Expect(resp.Allowed).To(BeTrue())
+			Expect(resp.Patches).To(BeEmpty())
+		})
+	})
+
+	It("should update the rules of the webhook configuration", func() {
+		resources, err := loadResources(resourcesConfig)
+		Expect(err).ToNot(HaveOccurred())
+
+		cfg := &admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "replacer-mutating-webhook-configuration"},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{
+				{Name: "other.example.com"},
+				{Name: webhookName},
+			},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(cfg).Build()
+
+		syncer := &ruleSyncer{client: c, reader: c, name: cfg.Name, rules: webhookRules(resources)}
+		Expect(syncer.Start(context.Background())).To(Succeed())
+
+		Expect(c.Get(context.Background(), types.NamespacedName{Name: cfg.Name}, cfg)).To(Succeed())
+		Expect(cfg.Webhooks[0].Rules).To(BeEmpty())
+
+		rules := cfg.Webhooks[1].Rules
+		Expect(rules).To(HaveLen(3))
+		Expect(rules[0].Resources).To(Equal([]string{"secrets", "secrets/*", "configmaps"}))
+		Expect(rules[1].APIGroups).To(Equal([]string{"apps"}))
+		Expect(rules[1].APIVersions).To(Equal([]string{"v1"}))
+		Expect(rules[1].Resources).To(Equal([]string{"deployments"}))
+		Expect(rules[2].APIVersions).To(Equal([]string{"*"}))
+	})
+
+	It("should not stop the manager if the rules cannot be updated", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		syncer := &ruleSyncer{client: c, reader: c, name: "missing", rules: webhookRules(nil)}
+		Expect(syncer.Start(context.Background())).To(Succeed())
+		Expect(syncer.NeedLeaderElection()).To(BeTrue())
+	})
+})
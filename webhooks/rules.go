@@ -0,0 +1,104 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// webhookName is the name of the replacer webhook in the mutating webhook
+// configuration.
+const webhookName = "replacer.agb.dev"
+
+// webhookRules returns the rules of the replacer webhook for secrets,
+// configmaps and the given resources.
+func webhookRules(resources []Resource) []admissionregistrationv1.RuleWithOperations {
+	operations := []admissionregistrationv1.OperationType{
+		admissionregistrationv1.Create,
+		admissionregistrationv1.Update,
+	}
+
+	rules := []admissionregistrationv1.RuleWithOperations{{
+		Operations: operations,
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"secrets", "secrets/*", "configmaps"},
+		},
+	}}
+	for _, res := range resources {
+		version := res.Version
+		if version == "" {
+			version = "*"
+		}
+
+		rules = append(rules, admissionregistrationv1.RuleWithOperations{
+			Operations: operations,
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{res.Group},
+				APIVersions: []string{version},
+				Resources:   []string{res.Resource},
+			},
+		})
+	}
+	return rules
+}
+
+// ruleSyncer updates the rules of the replacer webhook in a mutating webhook
+// configuration to match the configured resources when the manager starts.
+// It runs on the leader only, so that replicas do not race to update the
+// configuration.
+type ruleSyncer struct {
+	client client.Client
+	reader client.Reader
+	name   string
+	rules  []admissionregistrationv1.RuleWithOperations
+}
+
+// Start updates the rules. Failures are logged but do not stop the manager,
+// since the webhook keeps working with the rules of the configuration.
+func (s *ruleSyncer) Start(ctx context.Context) error {
+	log := logf.FromContext(ctx).WithValues("webhookConfiguration", s.name)
+
+	updated := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cfg := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		err := s.reader.Get(ctx, types.NamespacedName{Name: s.name}, cfg)
+		if err != nil {
+			return err
+		}
+
+		for i := range cfg.Webhooks {
+			if cfg.Webhooks[i].Name != webhookName {
+				continue
+			} else if equality.Semantic.DeepEqual(cfg.Webhooks[i].Rules, s.rules) {
+				return nil
+			}
+
+			cfg.Webhooks[i].Rules = s.rules
+			updated = true
+			return s.client.Update(ctx, cfg)
+		}
+		return fmt.Errorf("webhook %s not found", webhookName)
+	})
+	if err != nil {
+		log.Error(err, "failed to update the webhook rules")
+		return nil
+	}
+
+	if updated {
+		log.Info("updated webhook rules", "rules", len(s.rules))
+	}
+	return nil
+}
+
+// NeedLeaderElection returns true so that only the leader updates the rules.
+func (s *ruleSyncer) NeedLeaderElection() bool {
+	return true
+}
@@ -1,14 +1,89 @@
 package webhooks
 
 import (
+	"time"
+
+	"github.com/aar10n/replacer/internal/pkg/providers"
+	"github.com/aar10n/replacer/internal/pkg/providers/k8s"
+
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
-func RegisterWebhooksWithManager(mgr ctrl.Manager) error {
+// Options holds the settings for the webhooks.
+type Options struct {
+	// CrossNamespacePolicy controls whether the k8s provider may read objects
+	// in namespaces other than the one of the resource being replaced.
+	CrossNamespacePolicy k8s.CrossNamespacePolicy
+	// Timeout is the timeout configured for the webhooks in the API server.
+	// Replacements are aborted shortly before it expires.
+	Timeout time.Duration
+	// ProviderIdleTimeout is the time after which unused provider instances
+	// are closed.
+	ProviderIdleTimeout time.Duration
+	// MaxConcurrency is the maximum number of values fetched concurrently
+	// for a single request.
+	MaxConcurrency int
+	// ProviderQPS and ProviderBurst limit the rate of calls to each provider
+	// across all requests. A ProviderQPS of zero disables the limit.
+	ProviderQPS   float64
+	ProviderBurst int
+	// Resources are the other kinds of resources in which tags are replaced.
+	Resources []Resource
+	// WebhookConfigName is the name of the mutating webhook configuration. If
+	// set, its rules are updated to match the resources on start.
+	WebhookConfigName string
+	// EnforcePolicies limits the keys read for each request to those allowed
+	// by the ReplacerPolicies.
+	EnforcePolicies bool
+	// ManagerUser is the user name of the manager, whose requests are allowed
+	// without rendering or validating them again.
+	ManagerUser string
+}
+
+// RegisterWebhooksWithManager registers the replacer, validating and template
+// webhooks with the manager and returns the replacer webhook.
+func RegisterWebhooksWithManager(mgr ctrl.Manager, opts Options) (*ReplacerWebhook, error) {
+	pool := providers.NewPool(opts.ProviderIdleTimeout)
+	if err := mgr.Add(pool); err != nil {
+		return nil, err
+	}
+
+	hook := &ReplacerWebhook{
+		Client:          mgr.GetClient(),
+		Pool:            pool,
+		RateLimiter:     providers.NewRateLimiter(opts.ProviderQPS, opts.ProviderBurst),
+		MaxConcurrency:  opts.MaxConcurrency,
+		Timeout:         opts.Timeout,
+		Resources:       opts.Resources,
+		EnforcePolicies: opts.EnforcePolicies,
+		ManagerUser:     opts.ManagerUser,
+	}
+	// read objects directly from the API server, so that the webhook does not
+	// cache every secret of the cluster
+	k8s.Register(mgr.GetAPIReader(), opts.CrossNamespacePolicy)
+
+	if opts.WebhookConfigName != "" {
+		err := mgr.Add(&ruleSyncer{
+			client: mgr.GetClient(),
+			reader: mgr.GetAPIReader(),
+			name:   opts.WebhookConfigName,
+			rules:  webhookRules(opts.Resources),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	server := mgr.GetWebhookServer()
 	server.Register("/replace", &webhook.Admission{
-		Handler: &ReplacerWebhook{Client: mgr.GetClient()},
+		Handler: hook,
+	})
+	server.Register("/validate", &webhook.Admission{
+		Handler: &ValidatingWebhook{Client: mgr.GetClient(), ManagerUser: opts.ManagerUser},
+	})
+	server.Register("/template", &webhook.Admission{
+		Handler: &TemplateWebhook{},
 	})
-	return nil
+	return hook, nil
 }
@@ -0,0 +1,297 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aar10n/replacer/internal/pkg/digest"
+	ierrors "github.com/aar10n/replacer/internal/pkg/errors"
+	"github.com/aar10n/replacer/internal/pkg/replacer"
+
+	"k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// templatesAnnotation holds the original templates of the replaced values of
+// a resource, so that they can be rendered again when it is updated.
+const templatesAnnotation = replacerAnnotationPrefix + "templates"
+
+// ManagedLabel marks secrets and configmaps whose values are rendered by the
+// replacer, i.e. objects with stored templates and the targets of
+// ReplacerTemplates. The manager only caches the objects with this label.
+const ManagedLabel = replacerAnnotationPrefix + "managed"
+
+// HasTemplates returns true if obj has stored templates.
+func HasTemplates(obj metav1.Object) bool {
+	return obj.GetAnnotations()[templatesAnnotation] != ""
+}
+
+// storedTemplate is the original template of a replaced value.
+type storedTemplate struct {
+	Template string `json:"template"`
+	// Hash is the keyed hash of the rendered value. The template is only
+	// rendered again while the value has not been changed.
+	Hash string `json:"hash"`
+	// Sources are the providers and keys of the tags in the template.
+	Sources []string `json:"sources,omitempty"`
+	// Inputs is the keyed hash of the template and the values read to render
+	// it. The rendered value is kept while the inputs stay the same.
+	Inputs string `json:"inputs,omitempty"`
+	// Expanded is set for keys added from the expand annotation, whose
+	// template is the tag of the expanded secret.
+	Expanded bool `json:"expanded,omitempty"`
+}
+
+// templateSet holds the templates of the values of a resource, keyed by the
+// path of each value. The values of secrets and configmaps are keyed by
+// data.<key>, regardless of the field they are stored in.
+type templateSet map[string]*storedTemplate
+
+// loadTemplates loads the templates stored in the annotations of obj.
+func loadTemplates(obj metav1.Object) (templateSet, error) {
+	s := obj.GetAnnotations()[templatesAnnotation]
+	if s == "" {
+		return templateSet{}, nil
+	}
+
+	ts := templateSet{}
+	if err := json.Unmarshal([]byte(s), &ts); err != nil {
+		return nil, ierrors.New(ierrors.InvalidArgument, fmt.Sprintf("invalid %s value: %v", templatesAnnotation, err))
+	}
+	return ts, nil
+}
+
+// restore returns the template of the value at path if the value is the one
+// rendered from it. Otherwise the value is returned as-is. Rendered values
+// may contain tags, e.g. from escaped tags, which must not be replaced.
+func (ts templateSet) restore(path string, value string) string {
+	t, ok := ts[path]
+	if !ok || t.Expanded || !digest.Match(t.Hash, value) {
+		return value
+	}
+	return t.Template
+}
+
+// restoreData restores the templates of the values of a secret or configmap.
+func (ts templateSet) restoreData(data map[string]string) {
+	for k, v := range data {
+		data[k] = ts.restore(dataPath(k), v)
+	}
+}
+
+// dropExpanded removes the keys added from the expand annotation from data,
+// so that they are expanded again and fields removed from the expanded
+// secret are removed. Keys whose values were changed are kept. It returns the
+// removed keys and their values.
+func (ts templateSet) dropExpanded(data map[string]string) map[string]string {
+	dropped := make(map[string]string)
+	for k, v := range data {
+		if t, ok := ts[dataPath(k)]; ok && t.Expanded && digest.Match(t.Hash, v) {
+			dropped[k] = v
+			delete(data, k)
+		}
+	}
+	return dropped
+}
+
+// restorePaths restores the templates of values keyed by path.
+func (ts templateSet) restorePaths(values map[string]string) {
+	for k, v := range values {
+		values[k] = ts.restore(k, v)
+	}
+}
+
+// keep returns the previous value at path instead of the value rendered again
+// from its template while the inputs of the template are the same, so that
+// filters adding random salts (e.g. bcrypt) do not change the value on every
+// render.
+func (ts templateSet) keep(ctx context.Context, r *replacer.Replacer, path string, prev string, rendered string) string {
+	t, ok := ts[path]
+	if !ok || t.Expanded || t.Inputs == "" || !digest.Match(t.Hash, prev) {
+		return rendered
+	} else if inputsHash(ctx, r, t.Template) != t.Inputs {
+		return rendered
+	}
+	return prev
+}
+
+// keepData keeps the previous values of a secret or configmap as with keep.
+func (ts templateSet) keepData(ctx context.Context, r *replacer.Replacer, prev map[string]string, rendered map[string]string) {
+	for k, v := range rendered {
+		if p, ok := prev[k]; ok {
+			rendered[k] = ts.keep(ctx, r, dataPath(k), p, v)
+		}
+	}
+}
+
+// keepPaths keeps the previous values keyed by path as with keep.
+func (ts templateSet) keepPaths(ctx context.Context, r *replacer.Replacer, prev map[string]string, rendered map[string]string) {
+	for k, v := range rendered {
+		if p, ok := prev[k]; ok {
+			rendered[k] = ts.keep(ctx, r, k, p, v)
+		}
+	}
+}
+
+// record adds the template of the value at path if it contains any tags or
+// escaped tags.
+func (ts templateSet) record(ctx context.Context, r *replacer.Replacer, path string, tmpl string, value string) {
+	if !isTemplate(tmpl) {
+		return
+	}
+
+	t := &storedTemplate{Template: tmpl, Hash: digest.SumString(value), Inputs: inputsHash(ctx, r, tmpl)}
+	sources, _ := r.Sources(tmpl)
+	for _, src := range sources {
+		t.Sources = append(t.Sources, src.String())
+	}
+	ts[path] = t
+}
+
+// recordExpanded adds a key added from the expand annotation, given the tag
+// of the expanded secret.
+func (ts templateSet) recordExpanded(r *replacer.Replacer, path string, tag string, value string) {
+	t := &storedTemplate{Template: tag, Hash: digest.SumString(value), Expanded: true}
+	sources, _ := r.Sources(tag)
+	for _, src := range sources {
+		t.Sources = append(t.Sources, src.String())
+	}
+	ts[path] = t
+}
+
+// recordData adds the templates of the values of a secret or configmap, given
+// a function returning the stored value of a key.
+func (ts templateSet) recordData(ctx context.Context, r *replacer.Replacer, data map[string]string, value func(key string) string) {
+	for k, v := range data {
+		ts.record(ctx, r, dataPath(k), v, value(k))
+	}
+}
+
+// recordPaths adds the templates of values keyed by path.
+func (ts templateSet) recordPaths(ctx context.Context, r *replacer.Replacer, values map[string]string, newValues map[string]string) {
+	for k, v := range values {
+		ts.record(ctx, r, k, v, newValues[k])
+	}
+}
+
+// save stores the templates in the annotations of obj, or removes the
+// annotation if there are none, and sets ManagedLabel accordingly. It returns
+// true if the annotation or label changed.
+func (ts templateSet) save(obj metav1.Object) (bool, error) {
+	labelChanged := setManaged(obj, len(ts) > 0)
+
+	annotations := obj.GetAnnotations()
+	old, ok := annotations[templatesAnnotation]
+	if len(ts) == 0 {
+		if ok {
+			delete(annotations, templatesAnnotation)
+			obj.SetAnnotations(annotations)
+		}
+		return ok || labelChanged, nil
+	}
+
+	// keep tags readable in the annotation
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(ts); err != nil {
+		return false, err
+	}
+
+	b := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	if ok && old == string(b) {
+		return labelChanged, nil
+	}
+
+	// the api server limits the total size of the annotations
+	size := len(templatesAnnotation) + len(b)
+	for k, v := range annotations {
+		if k != templatesAnnotation {
+			size += len(k) + len(v)
+		}
+	}
+	if size > validation.TotalAnnotationSizeLimitB {
+		return false, ierrors.New(ierrors.InvalidArgument, fmt.Sprintf(
+			"the templates of %d values are too large to be stored in the %s annotation "+
+				"(%d bytes of annotations, the limit is %d bytes), split the values across several objects",
+			len(ts), templatesAnnotation, size, validation.TotalAnnotationSizeLimitB))
+	}
+
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[templatesAnnotation] = string(b)
+	obj.SetAnnotations(annotations)
+	return true, nil
+}
+
+// setManaged adds or removes ManagedLabel. It returns true if the labels
+// changed.
+func setManaged(obj metav1.Object, managed bool) bool {
+	labels := obj.GetLabels()
+	if (labels[ManagedLabel] == "true") == managed {
+		return false
+	}
+
+	if managed {
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[ManagedLabel] = "true"
+	} else {
+		delete(labels, ManagedLabel)
+	}
+	obj.SetLabels(labels)
+	return true
+}
+
+// dataPath returns the path of a key of a secret or configmap.
+func dataPath(key string) string {
+	return joinField("data", key)
+}
+
+// isTemplate returns true if s contains any replacement tags or escaped tags,
+// that is if rendering it changes it.
+func isTemplate(s string) bool {
+	tmpl, err := replacer.Parse(s)
+	if err != nil {
+		return false
+	} else if tmpl.HasTags() {
+		return true
+	}
+
+	var sb strings.Builder
+	for _, n := range tmpl.Nodes {
+		sb.WriteString(n.(*replacer.TextNode).Text)
+	}
+	return sb.String() != s
+}
+
+// inputsHash returns the keyed hash of a template and its inputs, or an
+// empty string if the inputs cannot be determined.
+func inputsHash(ctx context.Context, r *replacer.Replacer, tmpl string) string {
+	inputs, err := r.Inputs(ctx, tmpl)
+	if err != nil {
+		return ""
+	}
+	return digest.Inputs(tmpl, inputs)
+}
+
+// copyValues returns a copy of m.
+func copyValues(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// rendered returns true if the value at path is the one rendered from its
+// stored template.
+func (ts templateSet) rendered(path string, value string) bool {
+	t, ok := ts[path]
+	return ok && digest.Match(t.Hash, value)
+}
@@ -0,0 +1,187 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/aar10n/replacer/internal/pkg/digest"
+	"github.com/aar10n/replacer/internal/pkg/providers"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// rotatingValues are the values of the webhook-rotating provider, which can
+// be changed by tests.
+var rotatingValues = map[string]string{}
+
+func init() {
+	providers.Register("webhook-rotating", func() (providers.ValueProvider, error) {
+		return providers.NewTestProvider(rotatingValues), nil
+	})
+}
+
+var _ = Describe("Templates", func() {
+	var w *ReplacerWebhook
+
+	BeforeEach(func() {
+		w = newWebhook()
+		rotatingValues["password"] = "v1"
+	})
+
+	// create stores a secret with a templated password and returns it.
+	create := func() *corev1.Secret {
+		secret := &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: map[string]string{
+				"replacer.agb.dev/provider": "webhook-rotating",
+			}},
+			StringData: map[string]string{"password": "<replace:password>"},
+			Data:       map[string][]byte{"plain": []byte("plain")},
+		}
+
+		req := newRequest(secret)
+		stored := &corev1.Secret{}
+		applyResponse(req, w.Handle(context.Background(), req), stored)
+
+		// the api server merges stringData into data
+		for k, v := range stored.StringData {
+			stored.Data[k] = []byte(v)
+		}
+		stored.StringData = nil
+		return stored
+	}
+
+	// update sends an update of secret and returns the stored secret.
+	update := func(secret *corev1.Secret) *corev1.Secret {
+		req := newRequest(secret)
+		req.Operation = admissionv1.Update
+		stored := &corev1.Secret{}
+		applyResponse(req, w.Handle(context.Background(), req), stored)
+		return stored
+	}
+
+	It("should store the templates and sources of replaced values", func() {
+		stored := create()
+		Expect(stored.Data).To(HaveKeyWithValue("password", []byte("v1")))
+		Expect(stored.Labels).To(HaveKeyWithValue(ManagedLabel, "true"))
+
+		templates := templateSet{}
+		Expect(json.Unmarshal([]byte(stored.Annotations[templatesAnnotation]), &templates)).To(Succeed())
+		Expect(templates).To(HaveKey("data.password"))
+		Expect(templates["data.password"].Inputs).To(HavePrefix("hmac-sha256:"))
+		templates["data.password"].Inputs = ""
+		Expect(templates).To(Equal(templateSet{
+			"data.password": {
+				Template: "<replace:password>",
+				Hash:     digest.SumString("v1"),
+				Sources:  []string{"webhook-rotating:password"},
+			},
+		}))
+	})
+
+	It("should keep salted values while their inputs are unchanged", func() {
+		secret := &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: map[string]string{
+				"replacer.agb.dev/provider": "webhook-rotating",
+			}},
+			Data: map[string][]byte{"hash": []byte("<replace:password | bcrypt>")},
+		}
+
+		req := newRequest(secret)
+		stored := &corev1.Secret{}
+		applyResponse(req, w.Handle(context.Background(), req), stored)
+		hash := string(stored.Data["hash"])
+		Expect(hash).To(HavePrefix("$2a$"))
+
+		stored = update(stored)
+		Expect(stored.Data).To(HaveKeyWithValue("hash", []byte(hash)))
+
+		rotatingValues["password"] = "v2"
+		stored = update(stored)
+		Expect(stored.Data["hash"]).ToNot(Equal([]byte(hash)))
+	})
+
+	It("should render unchanged values again on update", func() {
+		stored := create()
+		rotatingValues["password"] = "v2"
+
+		stored = update(stored)
+		Expect(stored.Data).To(Equal(map[string][]byte{"password": []byte("v2"), "plain": []byte("plain")}))
+		Expect(stored.Annotations[templatesAnnotation]).To(ContainSubstring(digest.SumString("v2")))
+	})
+
+	It("should drop the templates of changed values", func() {
+		stored := create()
+		rotatingValues["password"] = "v2"
+
+		stored.Data["password"] = []byte("manual")
+		stored = update(stored)
+		Expect(stored.Data).To(HaveKeyWithValue("password", []byte("manual")))
+		Expect(stored.Annotations).ToNot(HaveKey(templatesAnnotation))
+		Expect(stored.Labels).ToNot(HaveKey(ManagedLabel))
+	})
+
+	It("should render new templates on update", func() {
+		stored := create()
+
+		stored.Data["password"] = []byte("<replace:password>-new")
+		stored = update(stored)
+		Expect(stored.Data).To(HaveKeyWithValue("password", []byte("v1-new")))
+		Expect(stored.Annotations[templatesAnnotation]).To(ContainSubstring(digest.SumString("v1-new")))
+	})
+
+	It("should keep escaped tags on update", func() {
+		secret := &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: map[string]string{
+				"replacer.agb.dev/provider": "webhook-rotating",
+			}},
+			Data: map[string][]byte{"docs": []byte("use <<replace:password> or <<replace")},
+		}
+
+		req := newRequest(secret)
+		stored := &corev1.Secret{}
+		applyResponse(req, w.Handle(context.Background(), req), stored)
+		Expect(stored.Data).To(HaveKeyWithValue("docs", []byte("use <replace:password> or <<replace")))
+
+		stored = update(stored)
+		Expect(stored.Data).To(HaveKeyWithValue("docs", []byte("use <replace:password> or <<replace")))
+
+		v := &ValidatingWebhook{Client: fake.NewClientBuilder().Build()}
+		decoder, err := admission.NewDecoder(scheme.Scheme)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v.InjectDecoder(decoder)).To(Succeed())
+
+		stored.TypeMeta = secret.TypeMeta
+		req = newRequest(stored)
+		req.Namespace = ""
+		resp := v.Handle(context.Background(), req)
+		Expect(resp.Allowed).To(BeTrue(), "%v", resp.Result)
+	})
+
+	It("should deny templates which exceed the annotation size limit", func() {
+		value := "<replace:password>" + strings.Repeat("x", 200*1024)
+		cm := &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: map[string]string{
+				"replacer.agb.dev/provider": "webhook-rotating",
+			}},
+			Data: map[string]string{"a": value, "b": value},
+		}
+
+		resp := w.Handle(context.Background(), newRequest(cm))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusBadRequest))
+		Expect(resp.Result.Message).To(ContainSubstring("the templates of 2 values are too large"))
+	})
+})
@@ -0,0 +1,241 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aar10n/replacer/api/v1alpha1"
+	"github.com/aar10n/replacer/internal/pkg/providers"
+	"github.com/aar10n/replacer/internal/pkg/replacer"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// templatedOnlyLabel marks namespaces in which the values of secrets must be
+// rendered from tags. Values which look like plaintext credentials are
+// rejected.
+const templatedOnlyLabel = replacerAnnotationPrefix + "templated-only"
+
+// ValidatingWebhook rejects secrets whose tags were not replaced, and secrets
+// and ReplacerTemplates with plaintext credentials in namespaces labeled as
+// templated-only. It runs after the replacer webhook, so tags are expected to
+// be replaced.
+type ValidatingWebhook struct {
+	Client client.Client
+	// ManagerUser is the user name of the manager, whose requests are not
+	// validated.
+	ManagerUser string
+	decoder     *admission.Decoder
+}
+
+func (w *ValidatingWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if w.ManagerUser != "" && req.UserInfo.Username == w.ManagerUser {
+		return admission.Allowed("rendered by the manager")
+	}
+
+	switch req.RequestKind.Kind {
+	case "Secret":
+		return w.validateSecret(ctx, req)
+	case "ReplacerTemplate":
+		return w.validateTemplate(ctx, req)
+	}
+	return admission.Allowed("not a secret or ReplacerTemplate")
+}
+
+// validateSecret rejects secrets with unresolved tags, and secrets with
+// plaintext credentials in templated-only namespaces.
+func (w *ValidatingWebhook) validateSecret(ctx context.Context, req admission.Request) admission.Response {
+	log := logf.FromContext(ctx)
+
+	secret := &corev1.Secret{}
+	if err := w.decoder.Decode(req, secret); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	values := make(map[string]string, len(secret.Data)+len(secret.StringData))
+	for k, v := range secret.Data {
+		values[k] = string(v)
+	}
+	for k, v := range secret.StringData {
+		values[k] = v
+	}
+
+	templates, err := loadTemplates(secret)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	problems := unresolvedTags(secret, templates, values)
+
+	templatedOnly, err := w.templatedOnly(ctx, req.Namespace)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	} else if templatedOnly {
+		problems = append(problems, plaintextCredentials(templates, values)...)
+	}
+
+	if len(problems) == 0 {
+		return admission.Allowed("")
+	}
+
+	log.Info("secret rejected", "name", secret.Name, "namespace", req.Namespace, "problems", problems)
+	return admission.Errored(http.StatusForbidden,
+		fmt.Errorf("%d values rejected:\n- %s", len(problems), strings.Join(problems, "\n- ")))
+}
+
+// validateTemplate rejects ReplacerTemplates with plaintext credentials in
+// their data in templated-only namespaces. Their targets are rendered by the
+// controller and not validated, so the text of the templates is checked
+// instead.
+func (w *ValidatingWebhook) validateTemplate(ctx context.Context, req admission.Request) admission.Response {
+	log := logf.FromContext(ctx)
+
+	tmpl := &v1alpha1.ReplacerTemplate{}
+	if err := w.decoder.Decode(req, tmpl); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	templatedOnly, err := w.templatedOnly(ctx, req.Namespace)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	} else if !templatedOnly {
+		return admission.Allowed("")
+	}
+
+	values := make(map[string]string, len(tmpl.Spec.Data))
+	for k, v := range tmpl.Spec.Data {
+		values[k] = templateText(v)
+	}
+	problems := plaintextCredentials(nil, values)
+	if len(problems) == 0 {
+		return admission.Allowed("")
+	}
+
+	log.Info("ReplacerTemplate rejected", "name", tmpl.Name, "namespace", req.Namespace, "problems", problems)
+	return admission.Errored(http.StatusForbidden,
+		fmt.Errorf("%d values rejected:\n- %s", len(problems), strings.Join(problems, "\n- ")))
+}
+
+func (w *ValidatingWebhook) InjectDecoder(d *admission.Decoder) error {
+	w.decoder = d
+	return nil
+}
+
+// templatedOnly returns true if a namespace is labeled as templated-only.
+func (w *ValidatingWebhook) templatedOnly(ctx context.Context, namespace string) (bool, error) {
+	if namespace == "" {
+		return false, nil
+	}
+
+	ns := &corev1.Namespace{}
+	if err := w.Client.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return false, fmt.Errorf("failed to get namespace %s: %w", namespace, err)
+	}
+	return ns.Labels[templatedOnlyLabel] == "true", nil
+}
+
+// unresolvedTags returns the problems of the tags left in the values of a
+// secret. Tags are malformed, cannot be resolved because of their provider,
+// or were not replaced. Tags of known providers are allowed if the secret
+// keeps unknown keys with the ignore_unknown_keys option, and values rendered
+// from stored templates (e.g. from escaped tags) are not checked.
+func unresolvedTags(secret *corev1.Secret, templates templateSet, values map[string]string) []string {
+	defaultProvider := secret.Annotations[replacerAnnotationPrefix+"provider"]
+	// an invalid value fails the replacement, so its tags are not kept
+	keepUnknown, _ := replacer.KeepsUnknownKeys(secret.Annotations[replacerAnnotationPrefix+"ignore_unknown_keys"])
+
+	var problems []string
+	for _, k := range sortedKeys(values) {
+		path := dataPath(k)
+		if templates.rendered(path, values[k]) {
+			continue
+		}
+
+		tmpl, err := replacer.Parse(values[k])
+		var perr *replacer.ParseError
+		if errors.As(err, &perr) {
+			problems = append(problems, fmt.Sprintf("%s: %s: malformed tag: %s", path, perr.Pos, perr.Msg))
+			continue
+		} else if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+
+		for _, tag := range tmpl.Tags() {
+			provider := tag.Provider
+			if provider == "" {
+				provider = defaultProvider
+			}
+
+			switch {
+			case provider == "":
+				problems = append(problems, fmt.Sprintf("%s: %s: tag %s has no provider", path, tag.Pos, tag.Raw))
+			case !providers.Registered(provider):
+				problems = append(problems, fmt.Sprintf("%s: %s: tag %s has unknown provider %s", path, tag.Pos, tag.Raw, provider))
+			case !keepUnknown:
+				problems = append(problems, fmt.Sprintf("%s: %s: tag %s was not replaced", path, tag.Pos, tag.Raw))
+			}
+		}
+	}
+	return problems
+}
+
+// plaintextCredentials returns the problems of values which look like
+// credentials but were not rendered from stored templates.
+func plaintextCredentials(templates templateSet, values map[string]string) []string {
+	var problems []string
+	for _, k := range sortedKeys(values) {
+		path := dataPath(k)
+		if templates.rendered(path, values[k]) {
+			continue
+		}
+
+		if kind := credentialKind(values[k]); kind != "" {
+			problems = append(problems, fmt.Sprintf("%s: value looks like %s, use a tag instead", path, kind))
+		}
+	}
+	return problems
+}
+
+// templateText returns the literal text of a template, i.e. its text and the
+// default values of its tags, each on a separate line. Templates which cannot
+// be parsed are returned as-is.
+func templateText(value string) string {
+	tmpl, err := replacer.Parse(value)
+	if err != nil {
+		return value
+	}
+
+	var lines []string
+	var walk func(nodes ...replacer.Node)
+	walk = func(nodes ...replacer.Node) {
+		for _, n := range nodes {
+			switch n := n.(type) {
+			case *replacer.TextNode:
+				lines = append(lines, n.Text)
+			case *replacer.TagNode:
+				if n.Default != nil {
+					walk(n.Default)
+				}
+			}
+		}
+	}
+	walk(tmpl.Nodes...)
+	return strings.Join(lines, "\n")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}